@@ -0,0 +1,85 @@
+// Command instagram-history exports the history recorded by the Instagram
+// module, reading the bridge's database directly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"modules-watgbridge/instagram/history"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func main() {
+	var (
+		dbType  = flag.String("db-type", "sqlite", "database type: sqlite, postgres or mysql")
+		dsn     = flag.String("dsn", "watgbridge.db", "database connection string, or file for sqlite")
+		format  = flag.String("format", "csv", "export format: csv or json")
+		output  = flag.String("o", "", "file to write to instead of stdout")
+		chat    = flag.String("chat", "", "only export this chat's JID")
+		owner   = flag.String("owner", "", "only export posts by this Instagram user")
+		keyword = flag.String("keyword", "", "only export entries matching this keyword")
+		from    = flag.String("from", "", "only export entries from this date (YYYY-MM-DD)")
+		to      = flag.String("to", "", "only export entries up to this date (YYYY-MM-DD)")
+	)
+	flag.Parse()
+
+	filter := history.Filter{Chat: *chat, Owner: *owner, Keyword: *keyword}
+	if *from != "" {
+		filter.From = mustParseDate(*from)
+	}
+	if *to != "" {
+		filter.To = mustParseDate(*to).AddDate(0, 0, 1)
+	}
+
+	var dialector gorm.Dialector
+	switch *dbType {
+	case "sqlite":
+		dialector = sqlite.Open(*dsn)
+	case "postgres":
+		dialector = postgres.Open(*dsn)
+	case "mysql":
+		dialector = mysql.Open(*dsn)
+	default:
+		fail(fmt.Errorf("unknown database type '%s'", *dbType))
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		fail(fmt.Errorf("could not open database : %s", err))
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fail(fmt.Errorf("could not create output file : %s", err))
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := history.Export(db, w, *format, filter); err != nil {
+		fail(err)
+	}
+}
+
+func mustParseDate(value string) time.Time {
+	date, err := time.ParseInLocation(history.DateLayout, value, time.Local)
+	if err != nil {
+		fail(fmt.Errorf("could not parse date '%s'", value))
+	}
+	return date
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
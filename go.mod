@@ -3,10 +3,16 @@ module modules-watgbridge
 go 1.20
 
 require (
+	github.com/PaulSonOfLars/gotgbot/v2 v2.0.0-rc.14
 	go.mau.fi/whatsmeow v0.0.0-20230204181151-b1f00ea99464
 	golang.org/x/exp v0.0.0-20230206171751-46f607a40771
+	golang.org/x/image v0.5.0
 	google.golang.org/protobuf v1.28.1
 	gopkg.in/yaml.v3 v3.0.1
+	gorm.io/driver/mysql v1.4.5
+	gorm.io/driver/postgres v1.4.6
+	gorm.io/driver/sqlite v1.4.4
+	gorm.io/gorm v1.24.5
 	watgbridge v0.0.0-00010101000000-000000000000
 )
 
@@ -14,7 +20,6 @@ require (
 	filippo.io/edwards25519 v1.0.0 // indirect
 	github.com/Benau/go_rlottie v0.0.0-20210807002906-98c1b2421989 // indirect
 	github.com/Benau/tgsconverter v0.0.0-20210809170556-99f4a4f6337f // indirect
-	github.com/PaulSonOfLars/gotgbot/v2 v2.0.0-rc.14 // indirect
 	github.com/av-elier/go-decimal-to-rational v0.0.0-20191127152832-89e6aad02ecf // indirect
 	github.com/forPelevin/gomoji v1.1.8 // indirect
 	github.com/go-sql-driver/mysql v1.7.0 // indirect
@@ -31,11 +36,7 @@ require (
 	github.com/sizeofint/webpanimation v0.0.0-20210809145948-1d2b32119882 // indirect
 	go.mau.fi/libsignal v0.1.0 // indirect
 	golang.org/x/crypto v0.5.0 // indirect
-	golang.org/x/text v0.6.0 // indirect
-	gorm.io/driver/mysql v1.4.5 // indirect
-	gorm.io/driver/postgres v1.4.6 // indirect
-	gorm.io/driver/sqlite v1.4.4 // indirect
-	gorm.io/gorm v1.24.5 // indirect
+	golang.org/x/text v0.7.0 // indirect
 )
 
 replace watgbridge => ../watgbridge
@@ -0,0 +1,272 @@
+package instagram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"modules-watgbridge/instagram/history"
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/proto"
+)
+
+func updateConfigAndReply(v *events.Message, chat waTypes.JID, change func(cfg *Config), text string) {
+	if err := UpdateConfig(change); err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not save the config:\n\n%s", err.Error()))
+		return
+	}
+	replyText(v, chat, text)
+}
+
+// replyGroupPolicyRule tells when a policy rule decides for the whole group,
+// as changing the allowed groups would then do nothing.
+func replyGroupPolicyRule(v *events.Message, chat waTypes.JID) bool {
+	rule, found := groupPolicyRule(chat)
+	if !found {
+		return false
+	}
+
+	name := "without a name"
+	if rule.Name != "" {
+		name = "*" + rule.Name + "*"
+	}
+	replyText(v, chat, fmt.Sprintf("The policy rule %s applies to this group and overrides "+
+		"the allowed groups, change it in the config instead", name))
+	return true
+}
+
+func igAllowCommand(v *events.Message, chat waTypes.JID, args []string) {
+	if chat.Server != waTypes.GroupServer {
+		replyText(v, chat, "This command can only be used in groups")
+		return
+	}
+
+	if replyGroupPolicyRule(v, chat) {
+		return
+	}
+
+	if slices.Contains(allowedGroups(), chat.User) {
+		replyText(v, chat, "This group is already allowed")
+		return
+	}
+
+	updateConfigAndReply(v, chat, func(cfg *Config) {
+		if !slices.Contains(cfg.WhatsAppAllowedGroups, chat.User) {
+			cfg.WhatsAppAllowedGroups = append(cfg.WhatsAppAllowedGroups, chat.User)
+		}
+	}, "Links will now be downloaded in this group")
+}
+
+func igDenyCommand(v *events.Message, chat waTypes.JID, args []string) {
+	if chat.Server != waTypes.GroupServer {
+		replyText(v, chat, "This command can only be used in groups")
+		return
+	}
+
+	if replyGroupPolicyRule(v, chat) {
+		return
+	}
+
+	if !slices.Contains(allowedGroups(), chat.User) {
+		replyText(v, chat, "This group is not allowed already")
+		return
+	}
+
+	updateConfigAndReply(v, chat, func(cfg *Config) {
+		if idx := slices.Index(cfg.WhatsAppAllowedGroups, chat.User); idx >= 0 {
+			cfg.WhatsAppAllowedGroups = slices.Delete(cfg.WhatsAppAllowedGroups, idx, idx+1)
+		}
+	}, "Links will no longer be downloaded in this group")
+}
+
+// allowedGroups returns a copy of the groups allowed with .igallow.
+func allowedGroups() []string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return slices.Clone(instaConfig.WhatsAppAllowedGroups)
+}
+
+func igGroupsCommand(v *events.Message, chat waTypes.JID, args []string) {
+	waClient := state.State.WhatsAppClient
+
+	groups := allowedGroups()
+	if len(groups) == 0 {
+		replyText(v, chat, "No groups are allowed")
+		return
+	}
+
+	lines := make([]string, 0, len(groups))
+	for _, group := range groups {
+		name := "_unknown group_"
+		groupInfo, err := waClient.GetGroupInfo(waTypes.NewJID(group, waTypes.GroupServer))
+		if err == nil && groupInfo.Name != "" {
+			name = groupInfo.Name
+		}
+		lines = append(lines, fmt.Sprintf("• %s\n  `%s`", name, group))
+	}
+
+	replyText(v, chat, "*Allowed groups*\n\n"+strings.Join(lines, "\n"))
+}
+
+func igSessionCommand(v *events.Message, chat waTypes.JID, args []string) {
+	var lines []string
+
+	status, err := CheckSession()
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("Session: could not be checked\n\n%s\n", err.Error()))
+	} else if status.LoggedIn {
+		lines = append(lines, fmt.Sprintf("Session: logged in as *@%s*", status.Username))
+	} else {
+		lines = append(lines, fmt.Sprintf("Session: *not logged in* (%s)", status.Message))
+	}
+
+	if missing := MissingSessionCookies(); len(missing) > 0 {
+		lines = append(lines, "Missing cookies: "+strings.Join(missing, ", "))
+	}
+
+	if err := BreakerError(); err != nil {
+		lines = append(lines, err.Error())
+	}
+
+	if IsPaused() {
+		lines = append(lines, "Downloads: *paused*")
+	} else {
+		lines = append(lines, "Downloads: running")
+	}
+
+	replyText(v, chat, "*Instagram session*\n\n"+strings.Join(lines, "\n"))
+}
+
+func igPauseCommand(v *events.Message, chat waTypes.JID, args []string) {
+	updateConfigAndReply(v, chat, func(cfg *Config) { cfg.Paused = true },
+		"Downloads paused, use *.igresume* to resume them")
+}
+
+func igResumeCommand(v *events.Message, chat waTypes.JID, args []string) {
+	updateConfigAndReply(v, chat, func(cfg *Config) { cfg.Paused = false }, "Downloads resumed")
+}
+
+func igExportCommand(v *events.Message, chat waTypes.JID, args []string) {
+	format, filter := "csv", history.Filter{Chat: chat.String()}
+	for _, arg := range args {
+		switch arg = strings.ToLower(arg); arg {
+		case "csv", "json":
+			format = arg
+		case "all":
+			filter.Chat = ""
+		default:
+			replyText(v, chat, "Usage: *.igexport [csv|json] [all]*")
+			return
+		}
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not open the database:\n\n%s", err.Error()))
+		return
+	}
+
+	var export bytes.Buffer
+	if err := history.Export(db, &export, format, filter); err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not export the history:\n\n%s", err.Error()))
+		return
+	}
+
+	mimetype := "text/csv"
+	if format == "json" {
+		mimetype = "application/json"
+	}
+	fileName := fmt.Sprintf("instagram_history_%s.%s", quotaNow().Format("2006-01-02"), format)
+
+	if err := sendDocument(v, chat, export.Bytes(), fileName, mimetype); err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not send the export:\n\n%s", err.Error()))
+	}
+}
+
+func sendDocument(v *events.Message, chat waTypes.JID, data []byte, fileName, mimetype string) error {
+	if len(data) > WhatsAppUploadLimit {
+		return ErrUploadLimit
+	}
+
+	uploaded, err := uploadWhatsAppMedia(context.Background(),
+		func() ([]byte, error) { return data, nil }, whatsmeow.MediaDocument)
+	if err != nil {
+		return err
+	}
+
+	_, err = sendWhatsAppMessage(chat, &waProto.Message{
+		DocumentMessage: &waProto.DocumentMessage{
+			Url:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			Mimetype:      proto.String(mimetype),
+			FileEncSha256: uploaded.FileEncSHA256,
+			FileSha256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			FileName:      proto.String(fileName),
+			Title:         proto.String(fileName),
+			ContextInfo:   replyContextInfo(v),
+		},
+	})
+	return err
+}
+
+func init() {
+	registerCommand(&WaCommand{
+		Name:      "igallow",
+		Usage:     "igallow",
+		Help:      "Allow downloads in this group",
+		OwnerOnly: true,
+		Handler:   igAllowCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "igdeny",
+		Usage:     "igdeny",
+		Help:      "Stop allowing downloads in this group",
+		OwnerOnly: true,
+		Handler:   igDenyCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "iggroups",
+		Usage:     "iggroups",
+		Help:      "List the allowed groups",
+		OwnerOnly: true,
+		Handler:   igGroupsCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "igsession",
+		Usage:     "igsession",
+		Help:      "Show whether the Instagram session still works",
+		OwnerOnly: true,
+		Handler:   igSessionCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "igexport",
+		Usage:     "igexport [csv|json] [all]",
+		Help:      "Export the history of this chat, or of all chats",
+		OwnerOnly: true,
+		Handler:   igExportCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "igpause",
+		Usage:     "igpause",
+		Help:      "Stop downloading links everywhere",
+		OwnerOnly: true,
+		Handler:   igPauseCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "igresume",
+		Usage:     "igresume",
+		Help:      "Resume downloading links",
+		OwnerOnly: true,
+		Handler:   igResumeCommand,
+	})
+}
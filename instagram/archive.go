@@ -0,0 +1,210 @@
+package instagram
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// How often old files are looked for when archiving
+const ArchivePruneInterval = time.Hour
+
+// ArchiveRequest is who asked for the media that is being archived.
+type ArchiveRequest struct {
+	Chat      string `json:"chat"`
+	Sender    string `json:"sender,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+func archiveRequestFor(v *events.Message, chat waTypes.JID) ArchiveRequest {
+	if v == nil {
+		return ArchiveRequest{Chat: chat.String()}
+	}
+	return ArchiveRequest{
+		Chat:      chat.String(),
+		Sender:    v.Info.MessageSource.Sender.ToNonAD().String(),
+		MessageID: v.Info.ID,
+	}
+}
+
+// ArchiveSidecar is saved next to every archived file as <file>.json.
+type ArchiveSidecar struct {
+	MediaID    string          `json:"media_id"`
+	Code       string          `json:"code"`
+	Index      int             `json:"index"`
+	Owner      string          `json:"owner"`
+	Caption    string          `json:"caption"`
+	TakenAt    time.Time       `json:"taken_at,omitempty"`
+	ArchivedAt time.Time       `json:"archived_at"`
+	Request    ArchiveRequest  `json:"request"`
+	Mimetype   string          `json:"mimetype"`
+	Size       int64           `json:"size"`
+	SHA256     string          `json:"sha256"`
+	Raw        json.RawMessage `json:"raw,omitempty"`
+}
+
+var (
+	archivePruneLock sync.Mutex
+	lastArchivePrune time.Time
+)
+
+func mediaFileExtension(mimetype string) string {
+	switch mimetype {
+	case "image/jpeg":
+		return ".jpg"
+	case "video/mp4":
+		return ".mp4"
+	}
+	if extensions, err := mime.ExtensionsByType(mimetype); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ".bin"
+}
+
+func archivesPost(post *InstagramPost) bool {
+	return instaConfig.Archive.Enabled || (post.IsStory && instaConfig.Archive.Stories)
+}
+
+// keepsPostFiles tells if the files of post are needed after sending it, to be
+// archived or mirrored to Telegram.
+func keepsPostFiles(post *InstagramPost) bool {
+	return archivesPost(post) || instaConfig.Telegram.MirrorMedia
+}
+
+// ArchivePost saves the downloaded files of a post under its DownloadPath(),
+// each with a sidecar describing it. files follows the order of post.Media,
+// and media that was not downloaded is nil and skipped. Nothing is done unless
+// archiving is enabled, or archive.stories is set for a story. The directory
+// of the post is returned.
+func ArchivePost(post *InstagramPost, files []*DownloadedFile, req ArchiveRequest) (string, error) {
+	if !archivesPost(post) || post.DownloadPath == "" {
+		return "", nil
+	}
+	defer pruneArchive()
+
+	dir := filepath.FromSlash(post.DownloadPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("could not create archive directory : %s", err)
+	}
+
+	var raw json.RawMessage
+	if json.Valid(post.Raw) {
+		raw = post.Raw
+	}
+
+	for idx, file := range files {
+		if file == nil || idx >= len(post.Media) {
+			continue
+		}
+
+		mediaID := post.Media[idx].MediaID
+		if mediaID == "" {
+			mediaID = post.ID
+		}
+		name := fmt.Sprintf("%02d_%s%s", idx+1, mediaID, mediaFileExtension(file.Mimetype))
+		filePath := filepath.Join(dir, name)
+
+		if err := copyFile(file.Path, filePath); err != nil {
+			return dir, fmt.Errorf("could not archive file : %s", err)
+		}
+
+		sidecar, err := json.MarshalIndent(ArchiveSidecar{
+			MediaID:    mediaID,
+			Code:       post.Code,
+			Index:      idx + 1,
+			Owner:      post.Owner,
+			Caption:    post.Caption,
+			TakenAt:    post.TakenAt,
+			ArchivedAt: time.Now(),
+			Request:    req,
+			Mimetype:   file.Mimetype,
+			Size:       file.Size,
+			SHA256:     hex.EncodeToString(file.SHA256),
+			Raw:        raw,
+		}, "", "  ")
+		if err != nil {
+			return dir, fmt.Errorf("could not marshal sidecar : %s", err)
+		}
+
+		if err := os.WriteFile(filePath+".json", sidecar, 0644); err != nil {
+			return dir, fmt.Errorf("could not write sidecar : %s", err)
+		}
+	}
+
+	return dir, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// ArchiveRoot is the directory all DownloadPath()s are in.
+func ArchiveRoot() string {
+	return filepath.Join("downloads", "instagram")
+}
+
+// pruneArchive removes archived files older than the retention, at most once
+// per ArchivePruneInterval. A retention of zero keeps everything.
+func pruneArchive() {
+	retention := instaConfig.Archive.Retention
+	if retention <= 0 {
+		return
+	}
+
+	archivePruneLock.Lock()
+	defer archivePruneLock.Unlock()
+
+	if time.Since(lastArchivePrune) < ArchivePruneInterval {
+		return
+	}
+	lastArchivePrune = time.Now()
+
+	var (
+		cutoff = time.Now().Add(-retention)
+		dirs   = make([]string, 0)
+	)
+	filepath.WalkDir(ArchiveRoot(), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(path)
+		}
+		return nil
+	})
+
+	// Deepest directories come last, and only empty ones can be removed
+	for idx := len(dirs) - 1; idx > 0; idx-- {
+		os.Remove(dirs[idx])
+	}
+}
@@ -0,0 +1,144 @@
+package instagram
+
+import (
+	"encoding/hex"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+const DefaultUploadCacheExpiry = 7 * 24 * time.Hour
+
+// UploadCacheEntry remembers a file that has already been uploaded to the
+// WhatsApp servers, along with everything needed to send it again.
+type UploadCacheEntry struct {
+	ID        uint   `gorm:"primaryKey"`
+	MediaID   string `gorm:"index"`
+	SHA256    string `gorm:"index"`
+	MediaType int
+
+	URL           string
+	DirectPath    string
+	MediaKey      []byte
+	FileEncSHA256 []byte
+	FileSHA256    []byte
+	FileLength    uint64
+	Mimetype      string
+
+	Thumbnail []byte
+	Seconds   uint32
+	Width     int32
+	Height    int32
+
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (UploadCacheEntry) TableName() string {
+	return "instagram_upload_cache"
+}
+
+func (entry *UploadCacheEntry) UploadResponse() whatsmeow.UploadResponse {
+	return whatsmeow.UploadResponse{
+		URL:           entry.URL,
+		DirectPath:    entry.DirectPath,
+		MediaKey:      entry.MediaKey,
+		FileEncSHA256: entry.FileEncSHA256,
+		FileSHA256:    entry.FileSHA256,
+		FileLength:    entry.FileLength,
+	}
+}
+
+func (entry *UploadCacheEntry) File() *DownloadedFile {
+	return &DownloadedFile{
+		Mimetype: entry.Mimetype,
+		SHA256:   entry.FileSHA256,
+		Size:     int64(entry.FileLength),
+	}
+}
+
+func (entry *UploadCacheEntry) Meta(caption string) MediaMeta {
+	return MediaMeta{
+		Caption:   caption,
+		Thumbnail: entry.Thumbnail,
+		Seconds:   entry.Seconds,
+		Width:     entry.Width,
+		Height:    entry.Height,
+	}
+}
+
+func uploadCacheExpiry() time.Duration {
+	if expiry := instaConfig.UploadCache.Expiry; expiry > 0 {
+		return expiry
+	}
+	return DefaultUploadCacheExpiry
+}
+
+// LookupUploadByMediaID returns a still valid upload of the given Instagram
+// media, or nil if there is none.
+func LookupUploadByMediaID(mediaID string, mediaType int) *UploadCacheEntry {
+	if !instaConfig.UploadCache.Enabled || mediaID == "" {
+		return nil
+	}
+	return lookupUpload("media_id = ? AND media_type = ?", mediaID, mediaType)
+}
+
+// LookupUploadBySHA256 returns a still valid upload of a file with the same
+// contents, or nil if there is none.
+func LookupUploadBySHA256(sha256 []byte, mediaType int) *UploadCacheEntry {
+	if !instaConfig.UploadCache.Enabled || len(sha256) == 0 {
+		return nil
+	}
+	return lookupUpload("sha256 = ? AND media_type = ?", hex.EncodeToString(sha256), mediaType)
+}
+
+func lookupUpload(query string, args ...interface{}) *UploadCacheEntry {
+	db, err := getDatabase()
+	if err != nil {
+		return nil
+	}
+
+	var entry UploadCacheEntry
+	res := db.Where(query, args...).
+		Where("expires_at > ?", time.Now()).
+		Order("expires_at DESC").
+		Limit(1).
+		Find(&entry)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil
+	}
+	return &entry
+}
+
+func StoreUpload(mediaID string, mediaType int, file *DownloadedFile,
+	uploaded whatsmeow.UploadResponse, meta MediaMeta) error {
+
+	if !instaConfig.UploadCache.Enabled {
+		return nil
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return err
+	}
+
+	// Expired entries are pruned whenever something new is cached
+	db.Where("expires_at <= ?", time.Now()).Delete(&UploadCacheEntry{})
+
+	return db.Create(&UploadCacheEntry{
+		MediaID:       mediaID,
+		SHA256:        hex.EncodeToString(file.SHA256),
+		MediaType:     mediaType,
+		URL:           uploaded.URL,
+		DirectPath:    uploaded.DirectPath,
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    uploaded.FileLength,
+		Mimetype:      file.Mimetype,
+		Thumbnail:     meta.Thumbnail,
+		Seconds:       meta.Seconds,
+		Width:         meta.Width,
+		Height:        meta.Height,
+		ExpiresAt:     time.Now().Add(uploadCacheExpiry()),
+	}).Error
+}
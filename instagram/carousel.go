@@ -0,0 +1,187 @@
+package instagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	CaptionLayoutFirst    = "first"
+	CaptionLayoutNumbered = "numbered"
+	CaptionLayoutSeparate = "separate"
+
+	DefaultCarouselConcurrency = 3
+)
+
+// sendCarousel sends every slide of the post and returns how many of them were
+// delivered.
+func sendCarousel(job *DownloadJob, post *InstagramPost, v *events.Message, chat waTypes.JID) int {
+	var (
+		slides      = post.Media
+		prepared    = make([]*PreparedMedia, len(slides))
+		concurrency = carouselConcurrency()
+		layout      = instaConfig.Carousel.CaptionLayout
+	)
+
+	errs := forEachBounded(len(slides), concurrency, func(ctx context.Context, idx int) error {
+		var err error
+		prepared[idx], err = PrepareMedia(ctx, job, slides[idx], "", v)
+		return err
+	})
+
+	for _, err := range errs {
+		if errors.Is(err, ErrJobSizeLimit) {
+			replyText(v, chat, fmt.Sprintf("Aborted downloading the post:\n\n%s", err.Error()))
+			return 0
+		}
+	}
+
+	files := make([]*DownloadedFile, len(prepared))
+	for idx, media := range prepared {
+		if media != nil {
+			files[idx] = media.File
+		}
+	}
+	ArchivePost(post, files, archiveRequestFor(v, chat))
+
+	var (
+		messages = make([]*waProto.Message, 0, len(slides))
+		indexes  = make([]int, 0, len(slides))
+		tooLarge = make([]*PreparedMedia, 0)
+		failed   = make([]string, 0)
+	)
+	for idx, media := range prepared {
+		if media == nil {
+			if errs[idx] != nil {
+				failed = append(failed, fmt.Sprintf("• Slide %d: %s", idx+1, errs[idx].Error()))
+			}
+		} else if media.TooLarge {
+			tooLarge = append(tooLarge, media)
+		} else {
+			messages = append(messages, media.Message)
+			indexes = append(indexes, idx)
+		}
+	}
+
+	caption := post.Caption
+	if layout != CaptionLayoutSeparate && len(messages) > 0 {
+		setMediaCaption(messages[0], caption)
+	}
+	if layout == CaptionLayoutNumbered {
+		for idx, media := range prepared {
+			if media == nil || media.TooLarge {
+				continue
+			}
+			msg := media.Message
+			label := fmt.Sprintf("*[%d/%d]*", idx+1, len(slides))
+			if existing := getMediaCaption(msg); existing != "" {
+				label += "\n\n" + existing
+			}
+			setMediaCaption(msg, label)
+		}
+	}
+
+	successfulUploads := 0
+	for idx, msg := range messages {
+		_, err := sendWhatsAppMessage(chat, msg)
+		if err == nil {
+			successfulUploads += 1
+		} else {
+			failed = append(failed, fmt.Sprintf("• Slide %d: %s", indexes[idx]+1, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		replyText(v, chat, fmt.Sprintf("Could not send %d of %d slides:\n\n%s", len(failed),
+			len(slides), strings.Join(failed, "\n")))
+	}
+
+	if successfulUploads > 0 && layout == CaptionLayoutSeparate {
+		replyText(v, chat, caption)
+	}
+
+	if len(tooLarge) > 0 {
+		OverflowToTelegram(v, chat, caption, tooLarge)
+	}
+
+	if successfulUploads > 0 {
+		mirrored := make([]*PreparedMedia, 0, len(prepared))
+		for _, media := range prepared {
+			// Media that is too large was already sent by the overflow
+			if media != nil && !media.TooLarge {
+				mirrored = append(mirrored, media)
+			}
+		}
+		MirrorToTelegram(job, chat, caption, mirrored)
+	}
+
+	return successfulUploads + len(tooLarge)
+}
+
+func getMediaCaption(msg *waProto.Message) string {
+	if msg.ImageMessage != nil {
+		return msg.ImageMessage.GetCaption()
+	} else if msg.VideoMessage != nil {
+		return msg.VideoMessage.GetCaption()
+	}
+	return ""
+}
+
+func setMediaCaption(msg *waProto.Message, caption string) {
+	if msg.ImageMessage != nil {
+		msg.ImageMessage.Caption = proto.String(caption)
+	} else if msg.VideoMessage != nil {
+		msg.VideoMessage.Caption = proto.String(caption)
+	}
+}
+
+func carouselConcurrency() int {
+	if concurrency := instaConfig.Carousel.Concurrency; concurrency > 0 {
+		return concurrency
+	}
+	return DefaultCarouselConcurrency
+}
+
+// forEachBounded calls fn for every index in [0, total) with at most
+// concurrency calls running at once. Once any call fails because the job size
+// limit was hit, the context is cancelled and the remaining calls are skipped.
+func forEachBounded(total, concurrency int, fn func(ctx context.Context, idx int) error) []error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		errs      = make([]error, total)
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, concurrency)
+	)
+	for idx := 0; idx < total; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				errs[idx] = ctx.Err()
+				return
+			}
+
+			errs[idx] = fn(ctx, idx)
+			if errors.Is(errs[idx], ErrJobSizeLimit) {
+				cancel()
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	return errs
+}
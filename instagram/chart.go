@@ -0,0 +1,193 @@
+package instagram
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	ChartWidth       = 900
+	ChartPanelHeight = 300
+	chartMargin      = 20
+	chartLabelWidth  = 64
+	chartGridLines   = 4
+)
+
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartText       = color.RGBA{0x26, 0x26, 0x26, 0xff}
+	chartGrid       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	chartLineColors = []color.RGBA{
+		{0xc1, 0x35, 0x84, 0xff},
+		{0x40, 0x5d, 0xe6, 0xff},
+	}
+)
+
+type ChartPoint struct {
+	Time  time.Time
+	Value int64
+}
+
+// ChartSeries is drawn as a line chart in a panel of its own.
+type ChartSeries struct {
+	Title  string
+	Points []ChartPoint
+}
+
+// RenderLineChart draws every series in a panel below the previous one, all
+// sharing the same time axis, and returns the chart as a PNG.
+func RenderLineChart(series ...ChartSeries) ([]byte, error) {
+	if len(series) == 0 {
+		return nil, fmt.Errorf("nothing to draw")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, ChartWidth, ChartPanelHeight*len(series)))
+	draw.Draw(img, img.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+
+	var from, to time.Time
+	for _, s := range series {
+		for _, point := range s.Points {
+			if from.IsZero() || point.Time.Before(from) {
+				from = point.Time
+			}
+			if point.Time.After(to) {
+				to = point.Time
+			}
+		}
+	}
+
+	for idx, s := range series {
+		panel := image.Rect(0, idx*ChartPanelHeight, ChartWidth, (idx+1)*ChartPanelHeight)
+		drawChartPanel(img, panel, s, from, to, chartLineColors[idx%len(chartLineColors)])
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("could not encode chart : %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func drawChartPanel(img *image.RGBA, panel image.Rectangle, s ChartSeries, from, to time.Time, lineColor color.RGBA) {
+	var (
+		face       = inconsolata.Regular8x16
+		lineHeight = face.Metrics().Height.Ceil()
+		plot       = image.Rect(
+			panel.Min.X+chartMargin+chartLabelWidth, panel.Min.Y+chartMargin+lineHeight*2,
+			panel.Max.X-chartMargin, panel.Max.Y-chartMargin-lineHeight-4,
+		)
+	)
+
+	drawChartText(img, s.Title, panel.Min.X+chartMargin, panel.Min.Y+chartMargin+lineHeight-4, chartText)
+
+	if len(s.Points) == 0 {
+		return
+	}
+
+	low, high := s.Points[0].Value, s.Points[0].Value
+	for _, point := range s.Points {
+		if point.Value < low {
+			low = point.Value
+		}
+		if point.Value > high {
+			high = point.Value
+		}
+	}
+	// Every grid line gets a distinct value, which also keeps a flat line in
+	// the middle rather than on an edge
+	if spread := high - low; spread < chartGridLines {
+		low -= (chartGridLines - spread) / 2
+		high = low + chartGridLines
+	}
+
+	for line := 0; line <= chartGridLines; line++ {
+		y := plot.Max.Y - line*plot.Dy()/chartGridLines
+		value := low + int64(line)*(high-low)/chartGridLines
+		fillRect(img, image.Rect(plot.Min.X, y, plot.Max.X, y+1), chartGrid)
+		label := fmt.Sprint(value)
+		if len(label) > chartLabelWidth/face.Advance {
+			label = FormatCount(value)
+		}
+		drawChartText(img, label, panel.Min.X+chartMargin, y+lineHeight/2-4, chartText)
+	}
+
+	dateLayout := "02 Jan"
+	if to.Sub(from) < 48*time.Hour {
+		dateLayout = "02 Jan 15:04"
+	}
+	labelY := plot.Max.Y + lineHeight + 2
+	drawChartText(img, from.In(quotaNow().Location()).Format(dateLayout), plot.Min.X, labelY, chartText)
+	toLabel := to.In(quotaNow().Location()).Format(dateLayout)
+	drawChartText(img, toLabel, plot.Max.X-font.MeasureString(face, toLabel).Ceil(), labelY, chartText)
+
+	var (
+		span  = to.Sub(from)
+		scale = func(point ChartPoint) image.Point {
+			x := plot.Min.X
+			if span > 0 {
+				x += int(float64(plot.Dx()) * float64(point.Time.Sub(from)) / float64(span))
+			}
+			y := plot.Max.Y - int(float64(plot.Dy())*float64(point.Value-low)/float64(high-low))
+			return image.Pt(x, y)
+		}
+	)
+
+	prev := scale(s.Points[0])
+	for _, point := range s.Points[1:] {
+		next := scale(point)
+		drawChartLine(img, prev, next, lineColor)
+		prev = next
+	}
+	for _, point := range s.Points {
+		center := scale(point)
+		fillRect(img, image.Rect(center.X-2, center.Y-2, center.X+3, center.Y+3), lineColor)
+	}
+}
+
+func drawChartText(img *image.RGBA, text string, x, y int, textColor color.RGBA) {
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(textColor),
+		Face: inconsolata.Regular8x16,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
+
+func fillRect(img *image.RGBA, rect image.Rectangle, fill color.RGBA) {
+	draw.Draw(img, rect, image.NewUniform(fill), image.Point{}, draw.Src)
+}
+
+// drawChartLine draws a 2px wide line from a to b.
+func drawChartLine(img *image.RGBA, a, b image.Point, lineColor color.RGBA) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	steps := absInt(dx)
+	if absInt(dy) > steps {
+		steps = absInt(dy)
+	}
+	if steps == 0 {
+		steps = 1
+	}
+
+	for step := 0; step <= steps; step++ {
+		x := a.X + dx*step/steps
+		y := a.Y + dy*step/steps
+		fillRect(img, image.Rect(x, y, x+2, y+2), lineColor)
+	}
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,183 @@
+package instagram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"strconv"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	ContactSheetCell       = 360
+	ContactSheetGap        = 6
+	ContactSheetMaxColumns = 4
+	ContactSheetQuality    = 85
+)
+
+var (
+	contactSheetBackground = color.RGBA{0x12, 0x12, 0x12, 0xff}
+	contactSheetBadge      = color.RGBA{0x00, 0x00, 0x00, 0xb0}
+	contactSheetNumber     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// RenderContactSheet lays the images at paths out in a grid of square cells,
+// in reading order and numbered from 1, and returns it as a JPEG. Images are
+// cropped to their center square, and the cells of images that cannot be read
+// are left empty.
+func RenderContactSheet(paths []string) ([]byte, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no images for the contact sheet")
+	}
+
+	columns := int(math.Ceil(math.Sqrt(float64(len(paths)))))
+	if columns > ContactSheetMaxColumns {
+		columns = ContactSheetMaxColumns
+	}
+	rows := (len(paths) + columns - 1) / columns
+
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		columns*ContactSheetCell+(columns+1)*ContactSheetGap,
+		rows*ContactSheetCell+(rows+1)*ContactSheetGap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(contactSheetBackground), image.Point{}, draw.Src)
+
+	for idx, path := range paths {
+		cell := contactSheetCell(idx, columns)
+		if img, err := decodeImageFile(path); err == nil {
+			draw.ApproxBiLinear.Scale(sheet, cell, img, centerSquare(img.Bounds()), draw.Src, nil)
+		}
+		drawCellNumber(sheet, cell, idx+1)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: ContactSheetQuality}); err != nil {
+		return nil, fmt.Errorf("could not encode contact sheet : %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func contactSheetCell(idx, columns int) image.Rectangle {
+	var (
+		x = ContactSheetGap + (idx%columns)*(ContactSheetCell+ContactSheetGap)
+		y = ContactSheetGap + (idx/columns)*(ContactSheetCell+ContactSheetGap)
+	)
+	return image.Rect(x, y, x+ContactSheetCell, y+ContactSheetCell)
+}
+
+// drawCellNumber puts number on a dark badge in the top left corner of cell,
+// drawn at twice the size of the font so that it is readable on phones.
+func drawCellNumber(sheet *image.RGBA, cell image.Rectangle, number int) {
+	var (
+		face   = inconsolata.Bold8x16
+		label  = strconv.Itoa(number)
+		ascent = face.Metrics().Ascent.Ceil()
+		badge  = image.NewRGBA(image.Rect(0, 0,
+			font.MeasureString(face, label).Ceil()+6, face.Metrics().Height.Ceil()+2))
+	)
+	draw.Draw(badge, badge.Bounds(), image.NewUniform(contactSheetBadge), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{
+		Dst:  badge,
+		Src:  image.NewUniform(contactSheetNumber),
+		Face: face,
+		Dot:  fixed.P(3, ascent+1),
+	}
+	drawer.DrawString(label)
+
+	corner := cell.Min.Add(image.Pt(8, 8))
+	target := image.Rectangle{Min: corner, Max: corner.Add(badge.Bounds().Size().Mul(2))}
+	draw.NearestNeighbor.Scale(sheet, target, badge, badge.Bounds(), draw.Over, nil)
+}
+
+func centerSquare(bounds image.Rectangle) image.Rectangle {
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// mediaPreviewLink returns a small image of src, which is its cover for
+// videos.
+func mediaPreviewLink(src MediaSource) string {
+	if len(src.Covers) > 0 {
+		return smallestCandidate(src.Covers, PreviewMinSide)
+	}
+	if src.MediaType == MediaTypeImage {
+		link, _ := src.Resolve()
+		return link
+	}
+	return ""
+}
+
+// downloadPreviews downloads a preview of every source, leaving the file nil
+// for the ones that could not be downloaded.
+func downloadPreviews(job *DownloadJob, sources []MediaSource) []*DownloadedFile {
+	files := make([]*DownloadedFile, len(sources))
+	forEachBounded(len(sources), carouselConcurrency(), func(ctx context.Context, idx int) error {
+		link := mediaPreviewLink(sources[idx])
+		if link == "" {
+			return fmt.Errorf("no preview available")
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+		if err != nil {
+			return err
+		}
+		file, err := job.Download(req)
+		if err != nil {
+			return err
+		}
+		files[idx] = file
+		return nil
+	})
+	return files
+}
+
+// sendContactSheet renders the previews into a contact sheet and sends it,
+// remembering what its numbers stand for. The size of the sheet is returned.
+func sendContactSheet(previews []*DownloadedFile, caption, kind string, links []string, v *events.Message,
+	chat waTypes.JID) (int64, error) {
+
+	paths := make([]string, len(previews))
+	for idx, preview := range previews {
+		if preview != nil {
+			paths[idx] = preview.Path
+		}
+	}
+
+	sheet, err := RenderContactSheet(paths)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := TempFile(sheet)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Remove()
+
+	res, err := SendImageFile(v, chat, file, caption)
+	if err != nil {
+		return 0, err
+	}
+	if err := RecordContactSheet(res.ID, chat, kind, links, len(paths)); err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not save the contact sheet, replies to it will not work:\n\n%s",
+			err.Error()))
+	}
+	return file.Size, nil
+}
@@ -0,0 +1,75 @@
+package instagram
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestContactSheetCell(t *testing.T) {
+	const step = ContactSheetCell + ContactSheetGap
+
+	tests := []struct {
+		idx     int
+		columns int
+		want    image.Point
+	}{
+		{0, 1, image.Pt(0, 0)},
+		{1, 1, image.Pt(0, 1)},
+		{1, 3, image.Pt(1, 0)},
+		{2, 3, image.Pt(2, 0)},
+		{3, 3, image.Pt(0, 1)},
+		{7, 4, image.Pt(3, 1)},
+		{8, 4, image.Pt(0, 2)},
+	}
+
+	for _, test := range tests {
+		corner := image.Pt(ContactSheetGap+test.want.X*step, ContactSheetGap+test.want.Y*step)
+		want := image.Rectangle{Min: corner, Max: corner.Add(image.Pt(ContactSheetCell, ContactSheetCell))}
+		if got := contactSheetCell(test.idx, test.columns); got != want {
+			t.Errorf("cell %d of %d columns: got %v, want %v", test.idx, test.columns, got, want)
+		}
+	}
+}
+
+func TestRenderContactSheetGrid(t *testing.T) {
+	tests := []struct {
+		images  int
+		columns int
+		rows    int
+	}{
+		{1, 1, 1},
+		{2, 2, 1},
+		{4, 2, 2},
+		{5, 3, 2},
+		{10, 4, 3},
+		{16, 4, 4},
+		{20, 4, 5},
+	}
+
+	for _, test := range tests {
+		// Images that cannot be read are left as empty cells
+		sheet, err := RenderContactSheet(make([]string, test.images))
+		if err != nil {
+			t.Fatalf("%d images: %v", test.images, err)
+		}
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(sheet))
+		if err != nil {
+			t.Fatalf("%d images: %v", test.images, err)
+		}
+
+		var (
+			width  = test.columns*ContactSheetCell + (test.columns+1)*ContactSheetGap
+			height = test.rows*ContactSheetCell + (test.rows+1)*ContactSheetGap
+		)
+		if config.Width != width || config.Height != height {
+			t.Errorf("%d images: got %dx%d, want %dx%d", test.images, config.Width, config.Height, width, height)
+		}
+	}
+
+	if _, err := RenderContactSheet(nil); err == nil {
+		t.Errorf("expected an error without images")
+	}
+}
@@ -0,0 +1,273 @@
+package instagram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"modules-watgbridge/instagram/history"
+	"watgbridge/utils"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const CommandPrefix = "."
+
+// Most entries shown by .ighistory
+const HistoryResultLimit = 10
+
+const (
+	ChatModeAuto    = "auto"
+	ChatModeCommand = "command"
+	ChatModeOff     = "off"
+)
+
+type WaCommand struct {
+	Name  string
+	Usage string
+	Help  string
+	// Owner only commands can only be used from the bridged account itself
+	OwnerOnly bool
+	// Lets the owner use the command in chats the policy denies, which owner
+	// only commands always can be
+	OwnerAnywhere bool
+	Handler       func(v *events.Message, chat waTypes.JID, args []string)
+}
+
+var waCommands = map[string]*WaCommand{}
+
+func registerCommand(cmd *WaCommand) {
+	waCommands[cmd.Name] = cmd
+}
+
+// handleCommand runs the command in text if there is one, and reports whether
+// the message was a command. It runs before the policy is checked, so that the
+// owner can still manage chats that a rule denies.
+func handleCommand(text string, v *events.Message, chat waTypes.JID, policy PolicyRule) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], CommandPrefix) {
+		return false
+	}
+
+	cmd, found := waCommands[strings.ToLower(strings.TrimPrefix(fields[0], CommandPrefix))]
+	if !found {
+		return false
+	}
+
+	if cmd.OwnerOnly && !v.Info.IsFromMe {
+		return true
+	}
+	if !cmd.OwnerOnly && !policy.Allowed() && !(cmd.OwnerAnywhere && v.Info.IsFromMe) {
+		return true
+	}
+	if !cmd.OwnerOnly && policy.Allowed() && policy.Mode == ChatModeOff {
+		return true
+	}
+
+	cmd.Handler(v, chat, fields[1:])
+	return true
+}
+
+// replyText replies to v, or just sends the text if there is no message to
+// reply to, like for subscriptions.
+func replyText(v *events.Message, chat waTypes.JID, text string) {
+	if v == nil {
+		utils.WaSendText(chat, text, "", "", nil, false)
+		return
+	}
+	utils.WaSendText(chat, text, v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
+		v.Message, true)
+}
+
+// ChatMode returns whether links are picked up automatically in the chat, only
+// through commands, or not at all.
+func ChatMode(chat waTypes.JID) string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return chatMode(chat)
+}
+
+// chatMode is ChatMode for callers that hold configLock.
+func chatMode(chat waTypes.JID) string {
+	if mode, found := instaConfig.ChatModes[chat.User]; found {
+		return mode
+	}
+	if instaConfig.DefaultChatMode != "" {
+		return instaConfig.DefaultChatMode
+	}
+	return ChatModeAuto
+}
+
+func igCommand(v *events.Message, chat waTypes.JID, args []string) {
+	if IsPaused() {
+		replyText(v, chat, "Downloads are paused right now")
+		return
+	}
+
+	force := len(args) > 0 && strings.ToLower(args[0]) == "force"
+	if force {
+		args = args[1:]
+	}
+
+	links := ExtractLinks(args...)
+	if len(args) == 0 {
+		// Only the replied to message is left once the command itself is skipped
+		texts := MessageTexts(v.Message, true)
+		if len(texts) > 0 {
+			links = ExtractLinks(texts[1:]...)
+		}
+	}
+
+	if !processLinks(links, v, chat, EffectivePolicy(v, chat), force) {
+		replyText(v, chat, "Usage: *.ig [force] <link>*, or reply to a message with a link using *.ig [force]*")
+	}
+}
+
+func igModeCommand(v *events.Message, chat waTypes.JID, args []string) {
+	if len(args) == 0 {
+		replyText(v, chat, fmt.Sprintf("Current mode: *%s*", ChatMode(chat)))
+		return
+	}
+
+	mode := strings.ToLower(args[0])
+	if mode != ChatModeAuto && mode != ChatModeCommand && mode != ChatModeOff {
+		replyText(v, chat, "Usage: *.igmode [auto|command|off]*")
+		return
+	}
+
+	err := UpdateConfig(func(cfg *Config) {
+		if cfg.ChatModes == nil {
+			cfg.ChatModes = make(map[string]string)
+		}
+		cfg.ChatModes[chat.User] = mode
+	})
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not save the config:\n\n%s", err.Error()))
+		return
+	}
+	replyText(v, chat, fmt.Sprintf("Mode changed to *%s*", mode))
+}
+
+func igPolicyCommand(v *events.Message, chat waTypes.JID, args []string) {
+	var (
+		policy = EffectivePolicy(v, chat)
+		usage  = GetQuotaUsage(quotaKey(chat, v.Info.MessageSource.Sender))
+	)
+
+	features := strings.Join(policy.Features, ", ")
+	if len(policy.Features) == 0 {
+		features = strings.Join(AllFeatures, ", ")
+	}
+
+	quota := "unlimited"
+	if policy.Quota.ItemsPerDay > 0 || policy.Quota.BytesPerDay > 0 {
+		limits := make([]string, 0, 2)
+		if policy.Quota.ItemsPerDay > 0 {
+			limits = append(limits, fmt.Sprintf("%d items", policy.Quota.ItemsPerDay))
+		}
+		if policy.Quota.BytesPerDay > 0 {
+			limits = append(limits, FormatBytes(policy.Quota.BytesPerDay))
+		}
+		quota = strings.Join(limits, ", ") + " per day"
+	}
+
+	replyText(v, chat, fmt.Sprintf("*Policy for you in this chat*\n\n"+
+		"Rule: *%s*\nAction: %s\nFeatures: %s\nMode: %s\nQuota: %s\n"+
+		"Used today: %d items, %s",
+		policy.Name, policy.Action, features, policy.Mode, quota,
+		usage.Items, FormatBytes(usage.Bytes)))
+}
+
+func igHistoryCommand(v *events.Message, chat waTypes.JID, args []string) {
+	filter, err := ParseHistoryFilter(args)
+	if err != nil {
+		replyText(v, chat, "Usage: *.ighistory [@user|keyword|YYYY-MM-DD[..YYYY-MM-DD]]*")
+		return
+	}
+	filter.Chat, filter.Limit = chat.String(), HistoryResultLimit
+
+	db, err := getDatabase()
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not open the database:\n\n%s", err.Error()))
+		return
+	}
+
+	entries, err := history.Find(db, filter)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not search the history:\n\n%s", err.Error()))
+		return
+	} else if len(entries) == 0 {
+		replyText(v, chat, "Nothing found")
+		return
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		sharedBy := entry.SenderName
+		if sharedBy == "" {
+			sharedBy = strings.Split(entry.Sender, "@")[0]
+		}
+		lines = append(lines, fmt.Sprintf("• %s · @%s · %s\n  shared by %s, %s",
+			entry.CreatedAt.In(quotaNow().Location()).Format("02 Jan 15:04"), entry.Owner,
+			entry.Link, sharedBy, entry.Outcome))
+	}
+
+	replyText(v, chat, "*History*\n\n"+strings.Join(lines, "\n"))
+}
+
+func igHelpCommand(v *events.Message, chat waTypes.JID, args []string) {
+	names := make([]string, 0, len(waCommands))
+	for name := range waCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	help := "*Instagram Downloader*\n\n"
+	for _, name := range names {
+		cmd := waCommands[name]
+		if cmd.OwnerOnly && !v.Info.IsFromMe {
+			continue
+		}
+		help += fmt.Sprintf("• *%s%s* : %s\n", CommandPrefix, cmd.Usage, cmd.Help)
+	}
+
+	replyText(v, chat, strings.TrimSpace(help))
+}
+
+func init() {
+	registerCommand(&WaCommand{
+		Name:    "ig",
+		Usage:   "ig [force] <link>",
+		Help:    "Download a link, or the link in the replied to message, force sends it even if it was shared recently",
+		Handler: igCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:      "igmode",
+		Usage:     "igmode [auto|command|off]",
+		Help:      "Pick up links automatically, only with .ig, or never in this chat",
+		OwnerOnly: true,
+		Handler:   igModeCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:          "igpolicy",
+		Usage:         "igpolicy",
+		Help:          "Show the policy rule that applies to you in this chat",
+		OwnerAnywhere: true,
+		Handler:       igPolicyCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:    "ighistory",
+		Usage:   "ighistory [@user|keyword|date range]",
+		Help:    "Search the links shared in this chat, dates look like 2023-01-01..2023-01-31",
+		Handler: igHistoryCommand,
+	})
+	registerCommand(&WaCommand{
+		Name:          "ighelp",
+		Usage:         "ighelp",
+		Help:          "Show this help",
+		OwnerAnywhere: true,
+		Handler:       igHelpCommand,
+	})
+}
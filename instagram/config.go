@@ -4,11 +4,19 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-var instaConfig *Config
+var (
+	instaConfig *Config
+	// Handlers run concurrently, so anything that changes the config or
+	// reads its maps holds this
+	configLock sync.RWMutex
+)
 
 type Config struct {
 	Path string `yaml:"-"`
@@ -17,6 +25,104 @@ type Config struct {
 	Headers map[string]string `yaml:"headers"`
 
 	WhatsAppAllowedGroups []string `yaml:"whatsapp_allowed_groups"`
+
+	// Set with .igpause and .igresume
+	Paused bool `yaml:"paused"`
+
+	// Rules are tried in order, see EffectivePolicy
+	Policy struct {
+		Rules []PolicyRule `yaml:"rules"`
+	} `yaml:"policy"`
+
+	RateLimits struct {
+		Sender TokenBucketConfig `yaml:"sender"`
+		Chat   TokenBucketConfig `yaml:"chat"`
+		// Used for policy rules without a quota of their own
+		Quota PolicyQuota `yaml:"quota"`
+	} `yaml:"rate_limits"`
+
+	API struct {
+		RequestsPerMinute int `yaml:"requests_per_minute"`
+		// Up to this fraction of the interval between requests is added to it
+		Jitter         float64 `yaml:"jitter"`
+		CircuitBreaker struct {
+			Failures int           `yaml:"failures"`
+			Cooldown time.Duration `yaml:"cooldown"`
+		} `yaml:"circuit_breaker"`
+	} `yaml:"api"`
+
+	Retries struct {
+		Attempts  int           `yaml:"attempts"`
+		BaseDelay time.Duration `yaml:"base_delay"`
+		MaxDelay  time.Duration `yaml:"max_delay"`
+	} `yaml:"retries"`
+
+	// Limits for a single attempt of each kind of call
+	Timeouts struct {
+		API      time.Duration `yaml:"api"`
+		Download time.Duration `yaml:"download"`
+		Upload   time.Duration `yaml:"upload"`
+		Send     time.Duration `yaml:"send"`
+	} `yaml:"timeouts"`
+
+	Archive struct {
+		Enabled bool `yaml:"enabled"`
+		// Archive stories even when archiving is not enabled
+		Stories bool `yaml:"stories"`
+		// Files older than this are removed, 0 keeps them forever
+		Retention time.Duration `yaml:"retention"`
+	} `yaml:"archive"`
+
+	Dedup struct {
+		// Media sent to a chat is not sent again within this, 0 disables it
+		Window time.Duration `yaml:"window"`
+	} `yaml:"dedup"`
+
+	Recheck struct {
+		Enabled bool `yaml:"enabled"`
+		// How often shared media is checked, for how long after sharing it
+		Interval time.Duration `yaml:"interval"`
+		Window   time.Duration `yaml:"window"`
+	} `yaml:"recheck"`
+
+	// How often each followed account is checked
+	Subscriptions struct {
+		PostsInterval   time.Duration `yaml:"posts_interval"`
+		StoriesInterval time.Duration `yaml:"stories_interval"`
+		ProfileInterval time.Duration `yaml:"profile_interval"`
+		StatsInterval   time.Duration `yaml:"stats_interval"`
+	} `yaml:"subscriptions"`
+
+	DefaultChatMode string            `yaml:"default_chat_mode"`
+	ChatModes       map[string]string `yaml:"chat_modes"`
+
+	Downloads struct {
+		TempDirectory string `yaml:"temp_directory"`
+		MaxFileSize   int64  `yaml:"max_file_size"`
+		MaxJobSize    int64  `yaml:"max_job_size"`
+	} `yaml:"downloads"`
+
+	Carousel struct {
+		Concurrency   int    `yaml:"concurrency"`
+		CaptionLayout string `yaml:"caption_layout"`
+		// Send a single numbered grid of the slides instead of every slide
+		ContactSheet bool `yaml:"contact_sheet"`
+	} `yaml:"carousel"`
+
+	UploadCache struct {
+		Enabled bool          `yaml:"enabled"`
+		Expiry  time.Duration `yaml:"expiry"`
+	} `yaml:"upload_cache"`
+
+	Telegram struct {
+		MirrorMedia  bool    `yaml:"mirror_media"`
+		AllowedChats []int64 `yaml:"allowed_chats"`
+	} `yaml:"telegram"`
+
+	Overflow struct {
+		WhatsAppMediaLimit int64 `yaml:"whatsapp_media_limit"`
+		TelegramChannelID  int64 `yaml:"telegram_channel_id"`
+	} `yaml:"overflow"`
 }
 
 func (cfg *Config) LoadConfig() error {
@@ -45,25 +151,64 @@ func (cfg *Config) LoadConfig() error {
 	return nil
 }
 
+// UpdateConfig applies change to the config and saves it, all under
+// configLock, so that a change cannot interleave with another change or save.
+func UpdateConfig(change func(cfg *Config)) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	change(instaConfig)
+	return instaConfig.writeConfig()
+}
+
+// IsPaused returns whether downloads were paused with .igpause.
+func IsPaused() bool {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return instaConfig.Paused
+}
+
 func (cfg *Config) SaveConfig() error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	return cfg.writeConfig()
+}
+
+// writeConfig replaces the config file with a fully written temporary file, so
+// that a failed or concurrent save never leaves it truncated. configLock must
+// be held.
+func (cfg *Config) writeConfig() error {
 	configFilePath := cfg.Path
 
-	configFile, err := os.Create(configFilePath)
+	newConfigBody, err := yaml.Marshal(cfg)
 	if err != nil {
-		return fmt.Errorf("could not open config file : %s", err)
+		return fmt.Errorf("failed to marshal config into string : %s", err)
 	}
-	defer configFile.Close()
 
-	newConfigBody, err := yaml.Marshal(cfg)
+	tempFile, err := os.CreateTemp(filepath.Dir(configFilePath), filepath.Base(configFilePath)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to marshal config into string : %s", err)
+		return fmt.Errorf("could not create temporary config file : %s", err)
 	}
+	defer os.Remove(tempFile.Name())
 
-	_, err = configFile.Write(newConfigBody)
+	if info, err := os.Stat(configFilePath); err == nil {
+		tempFile.Chmod(info.Mode().Perm())
+	}
+
+	_, err = tempFile.Write(newConfigBody)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write config file : %s", err)
 	}
 
+	if err := os.Rename(tempFile.Name(), configFilePath); err != nil {
+		return fmt.Errorf("could not replace config file : %s", err)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,47 @@
+package instagram
+
+import (
+	"fmt"
+	"sync"
+
+	"modules-watgbridge/instagram/history"
+	"watgbridge/state"
+
+	"gorm.io/gorm"
+)
+
+var (
+	migrateOnce  sync.Once
+	migrateError error
+)
+
+// getDatabase returns the bridge's database after making sure that the tables
+// used by this module exist.
+func getDatabase() (*gorm.DB, error) {
+	migrateOnce.Do(func() {
+		db := state.State.Database
+		if db == nil {
+			migrateError = fmt.Errorf("database is not initialised")
+			return
+		}
+
+		err := db.AutoMigrate(
+			&UploadCacheEntry{},
+			&history.Entry{},
+			&Subscription{},
+			&SeenItem{},
+			&ProfileSnapshot{},
+			&SharedMedia{},
+			&ContactSheet{},
+			&QuotaUsage{},
+		)
+		if err != nil {
+			migrateError = fmt.Errorf("could not migrate instagram tables : %s", err)
+		}
+	})
+
+	if migrateError != nil {
+		return nil, migrateError
+	}
+	return state.State.Database, nil
+}
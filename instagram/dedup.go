@@ -0,0 +1,111 @@
+package instagram
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"modules-watgbridge/instagram/history"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Shortcodes are media IDs written in base 64 with this alphabet
+const shortcodeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// ShortcodeToMediaID decodes the shortcode in a post link into the numeric
+// media ID, returning an empty string if it is not a valid shortcode.
+func ShortcodeToMediaID(code string) string {
+	// Private posts have a longer shortcode of which only the start is the ID
+	if len(code) > 28 {
+		code = code[:len(code)-28]
+	}
+	if code == "" {
+		return ""
+	}
+
+	var (
+		id   = new(big.Int)
+		base = big.NewInt(64)
+	)
+	for _, char := range code {
+		value := strings.IndexRune(shortcodeAlphabet, char)
+		if value < 0 {
+			return ""
+		}
+		id.Mul(id, base).Add(id, big.NewInt(int64(value)))
+	}
+	return id.String()
+}
+
+// CanonicalMediaID strips the owner's ID that the API appends to media IDs.
+func CanonicalMediaID(id string) string {
+	mediaID, _, _ := strings.Cut(id, "_")
+	return mediaID
+}
+
+func dedupWindow() time.Duration {
+	return instaConfig.Dedup.Window
+}
+
+// FindEarlierShare returns the last time the media was sent to the chat within
+// the dedup window, or nil if it was not or dedup is disabled. chat is a JID,
+// or a Telegram chat as telegramChatKey returns it.
+func FindEarlierShare(chat, mediaID string) *history.Entry {
+	if dedupWindow() <= 0 || mediaID == "" {
+		return nil
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return nil
+	}
+
+	var entry history.Entry
+	err = db.Where("chat = ? AND media_id = ? AND outcome != ? AND created_at > ?",
+		chat, mediaID, history.Failed, time.Now().Add(-dedupWindow())).
+		Order("created_at DESC").First(&entry).Error
+	if err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// FormatAgo rounds a duration for saying how long ago something happened.
+func FormatAgo(duration time.Duration) string {
+	switch {
+	case duration < time.Minute:
+		return "just now"
+	case duration < time.Hour:
+		return fmt.Sprintf("%dm ago", int(duration.Minutes()))
+	case duration < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(duration.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(duration.Hours()/24))
+	}
+}
+
+// replyAlreadyShared points to the message in which the media was shared
+// before, quoting it. Shares without a message, like those of subscriptions,
+// are linked instead.
+func replyAlreadyShared(v *events.Message, chat waTypes.JID, earlier *history.Entry) {
+	sharedBy := earlier.SenderName
+	if sharedBy == "" {
+		sharedBy = strings.Split(earlier.Sender, "@")[0]
+	}
+
+	text := fmt.Sprintf("Already shared by %s %s, use *.ig force <link>* to send it again",
+		sharedBy, FormatAgo(time.Since(earlier.CreatedAt)))
+	if earlier.MessageID == "" {
+		replyText(nil, chat, text+"\n\n"+earlier.Link)
+		return
+	}
+
+	utils.WaSendText(chat, text, earlier.MessageID, earlier.Sender,
+		&waProto.Message{Conversation: proto.String(earlier.Link)}, true)
+}
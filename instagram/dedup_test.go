@@ -0,0 +1,31 @@
+package instagram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortcodeToMediaID(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"single character", "B", "1"},
+		{"second digit", "BA", "64"},
+		{"last character", "_", "63"},
+		{"post", "CpFT3mLBmDn", "3045928106428096743"},
+		{"post with dash and underscore", "Cz-_a9B0x1y", "3242307710918794610"},
+		{"private post", "CpFT3mLBmDn" + strings.Repeat("x", 28), "3045928106428096743"},
+		{"empty", "", ""},
+		{"invalid character", "Cp.T3mLBmDn", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ShortcodeToMediaID(test.code); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package instagram
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	ErrFileSizeLimit = errors.New("file is larger than the per-file download limit")
+	ErrJobSizeLimit  = errors.New("media is larger than the per-job download limit")
+)
+
+type DownloadedFile struct {
+	Path     string
+	Mimetype string
+	SHA256   []byte
+	Size     int64
+
+	local bool
+}
+
+func (df *DownloadedFile) Bytes() ([]byte, error) {
+	return os.ReadFile(df.Path)
+}
+
+func (df *DownloadedFile) Remove() {
+	if !df.local {
+		os.Remove(df.Path)
+	}
+}
+
+// DownloadFile streams the response body into a temporary file, hashing it on
+// the way. A limit of zero or less means the file size is not capped.
+func DownloadFile(req *http.Request, limit int64) (*DownloadedFile, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error making request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, StatusError(res)
+	}
+
+	if limit > 0 && res.ContentLength > limit {
+		return nil, ErrFileSizeLimit
+	}
+
+	tempFile, err := os.CreateTemp(instaConfig.Downloads.TempDirectory, "instagram-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file : %s", err)
+	}
+	defer tempFile.Close()
+
+	var (
+		hasher           = sha256.New()
+		sniff            = &sniffWriter{}
+		body   io.Reader = res.Body
+	)
+	if limit > 0 {
+		body = io.LimitReader(res.Body, limit+1)
+	}
+
+	size, err := io.Copy(io.MultiWriter(tempFile, hasher, sniff), body)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return nil, fmt.Errorf("could not save response body : %w", err)
+	}
+	if limit > 0 && size > limit {
+		os.Remove(tempFile.Name())
+		return nil, ErrFileSizeLimit
+	}
+
+	return &DownloadedFile{
+		Path:     tempFile.Name(),
+		Mimetype: http.DetectContentType(sniff.buf),
+		SHA256:   hasher.Sum(nil),
+		Size:     size,
+	}, nil
+}
+
+// TempFile saves data into a temporary file, like one that was downloaded.
+func TempFile(data []byte) (*DownloadedFile, error) {
+	tempFile, err := os.CreateTemp(instaConfig.Downloads.TempDirectory, "instagram-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file : %s", err)
+	}
+	defer tempFile.Close()
+
+	if _, err := tempFile.Write(data); err != nil {
+		os.Remove(tempFile.Name())
+		return nil, fmt.Errorf("could not write temporary file : %s", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return &DownloadedFile{
+		Path:     tempFile.Name(),
+		Mimetype: http.DetectContentType(data),
+		SHA256:   sum[:],
+		Size:     int64(len(data)),
+	}, nil
+}
+
+// LocalFile describes a file that is already on disk, like an archived one.
+// It is not removed by Remove() unlike downloaded files.
+func LocalFile(filePath string) (*DownloadedFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		hasher = sha256.New()
+		sniff  = &sniffWriter{}
+	)
+	size, err := io.Copy(io.MultiWriter(hasher, sniff), file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DownloadedFile{
+		Path:     filePath,
+		Mimetype: http.DetectContentType(sniff.buf),
+		SHA256:   hasher.Sum(nil),
+		Size:     size,
+		local:    true,
+	}, nil
+}
+
+// sniffWriter keeps the first bytes written to it, which is all that
+// http.DetectContentType looks at.
+type sniffWriter struct {
+	buf []byte
+}
+
+func (sw *sniffWriter) Write(p []byte) (int, error) {
+	if remaining := 512 - len(sw.buf); remaining > 0 {
+		if len(p) < remaining {
+			remaining = len(p)
+		}
+		sw.buf = append(sw.buf, p[:remaining]...)
+	}
+	return len(p), nil
+}
+
+// DownloadJob tracks all the files fetched for a single link so that the
+// per-job limit can be enforced and the temporary files removed afterwards.
+type DownloadJob struct {
+	MaxFileSize int64
+	MaxJobSize  int64
+	// Download media even when an earlier upload of it can be reused, as the
+	// files are archived or mirrored
+	KeepFiles bool
+
+	lock      sync.Mutex
+	files     []*DownloadedFile
+	totalSize int64
+}
+
+func NewDownloadJob() *DownloadJob {
+	return &DownloadJob{
+		MaxFileSize: instaConfig.Downloads.MaxFileSize,
+		MaxJobSize:  instaConfig.Downloads.MaxJobSize,
+	}
+}
+
+func (job *DownloadJob) Download(req *http.Request) (*DownloadedFile, error) {
+	job.lock.Lock()
+	limit := job.MaxFileSize
+	if job.MaxJobSize > 0 {
+		remaining := job.MaxJobSize - job.totalSize
+		if remaining <= 0 {
+			job.lock.Unlock()
+			return nil, ErrJobSizeLimit
+		}
+		if limit <= 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	job.lock.Unlock()
+
+	var df *DownloadedFile
+	err := Retry(req.Context(), downloadTimeout(), IsRetryable, func(ctx context.Context) error {
+		var err error
+		df, err = DownloadFile(req.WithContext(ctx), limit)
+		return err
+	})
+	if errors.Is(err, ErrFileSizeLimit) && job.MaxJobSize > 0 &&
+		(job.MaxFileSize <= 0 || limit < job.MaxFileSize) {
+		return nil, ErrJobSizeLimit
+	} else if err != nil {
+		return nil, err
+	}
+
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	// Parallel downloads are only checked against the total once they finish
+	if job.MaxJobSize > 0 && job.totalSize+df.Size > job.MaxJobSize {
+		df.Remove()
+		return nil, ErrJobSizeLimit
+	}
+
+	job.files = append(job.files, df)
+	job.totalSize += df.Size
+	return df, nil
+}
+
+// TotalSize returns the number of bytes downloaded so far.
+func (job *DownloadJob) TotalSize() int64 {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	return job.totalSize
+}
+
+func (job *DownloadJob) Cleanup() {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	for _, df := range job.files {
+		df.Remove()
+	}
+	job.files = nil
+}
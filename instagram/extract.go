@@ -0,0 +1,201 @@
+package instagram
+
+import (
+	"net/url"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+// Wrapped messages are not followed deeper than this
+const maxMessageNesting = 4
+
+// MessageTexts returns every piece of user written text in msg, including the
+// captions of media and the contents of wrapper messages like view once or
+// ephemeral ones. The quoted message of a reply is included if withQuoted is
+// set.
+func MessageTexts(msg *waProto.Message, withQuoted bool) []string {
+	texts := make([]string, 0, 1)
+	collectMessageTexts(msg, withQuoted, 0, &texts)
+	return texts
+}
+
+func collectMessageTexts(msg *waProto.Message, withQuoted bool, depth int, texts *[]string) {
+	if msg == nil || depth > maxMessageNesting {
+		return
+	}
+
+	add := func(values ...string) {
+		for _, value := range values {
+			if value != "" {
+				*texts = append(*texts, value)
+			}
+		}
+	}
+
+	var (
+		extendedText = msg.GetExtendedTextMessage()
+		buttons      = msg.GetButtonsMessage()
+		template     = msg.GetTemplateMessage()
+	)
+
+	add(msg.GetConversation(), extendedText.GetText(), extendedText.GetMatchedText())
+	add(msg.GetImageMessage().GetCaption(), msg.GetVideoMessage().GetCaption(),
+		msg.GetDocumentMessage().GetCaption())
+	add(buttons.GetContentText(), buttons.GetText())
+	add(template.GetHydratedTemplate().GetHydratedContentText(),
+		template.GetHydratedFourRowTemplate().GetHydratedContentText())
+	add(msg.GetListMessage().GetDescription())
+	add(msg.GetPollCreationMessage().GetName(), msg.GetPollCreationMessageV2().GetName())
+	add(msg.GetInteractiveMessage().GetBody().GetText())
+
+	for _, inner := range wrappedMessages(msg) {
+		collectMessageTexts(inner, withQuoted, depth+1, texts)
+	}
+
+	if !withQuoted {
+		return
+	}
+
+	for _, contextInfo := range messageContextInfos(msg) {
+		collectMessageTexts(contextInfo.GetQuotedMessage(), false, depth+1, texts)
+	}
+}
+
+// QuotedMessageID returns the ID of the message that msg replies to, looking
+// into wrapper messages the same way as MessageTexts.
+func QuotedMessageID(msg *waProto.Message) string {
+	return findQuotedMessageID(msg, 0)
+}
+
+func findQuotedMessageID(msg *waProto.Message, depth int) string {
+	if msg == nil || depth > maxMessageNesting {
+		return ""
+	}
+
+	for _, contextInfo := range messageContextInfos(msg) {
+		if id := contextInfo.GetStanzaId(); id != "" {
+			return id
+		}
+	}
+	for _, inner := range wrappedMessages(msg) {
+		if id := findQuotedMessageID(inner, depth+1); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// wrappedMessages returns the messages that msg wraps, like the contents of a
+// view once or ephemeral message.
+func wrappedMessages(msg *waProto.Message) []*waProto.Message {
+	return []*waProto.Message{
+		msg.GetViewOnceMessage().GetMessage(),
+		msg.GetViewOnceMessageV2().GetMessage(),
+		msg.GetViewOnceMessageV2Extension().GetMessage(),
+		msg.GetEphemeralMessage().GetMessage(),
+		msg.GetDocumentWithCaptionMessage().GetMessage(),
+		msg.GetEditedMessage().GetMessage(),
+		msg.GetGroupMentionedMessage().GetMessage(),
+		msg.GetDeviceSentMessage().GetMessage(),
+		msg.GetProtocolMessage().GetEditedMessage(),
+	}
+}
+
+// messageContextInfos returns the context infos of the kinds of messages that
+// can be replies.
+func messageContextInfos(msg *waProto.Message) []*waProto.ContextInfo {
+	return []*waProto.ContextInfo{
+		msg.GetExtendedTextMessage().GetContextInfo(),
+		msg.GetImageMessage().GetContextInfo(),
+		msg.GetVideoMessage().GetContextInfo(),
+		msg.GetDocumentMessage().GetContextInfo(),
+		msg.GetButtonsMessage().GetContextInfo(),
+		msg.GetTemplateMessage().GetContextInfo(),
+		msg.GetListMessage().GetContextInfo(),
+		msg.GetPollCreationMessage().GetContextInfo(),
+		msg.GetInteractiveMessage().GetContextInfo(),
+	}
+}
+
+// ExtractLinks returns the Instagram links found in texts, with links pointing
+// to the same post, story or profile only returned once.
+func ExtractLinks(texts ...string) []string {
+	var (
+		links = make([]string, 0)
+		seen  = make(map[string]bool)
+	)
+
+	for _, text := range texts {
+		for _, token := range strings.Fields(text) {
+			link := normalizeLinkToken(token)
+			if !IsInstagramLink(link) {
+				continue
+			}
+
+			key := canonicalLinkKey(link)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// normalizeLinkToken strips the punctuation and formatting that commonly
+// surrounds a link in a message, and adds the scheme if it is missing.
+func normalizeLinkToken(token string) string {
+	token = strings.Trim(token, "()<>[]{}\"'`,!?*~")
+	token = strings.TrimRight(token, ".;:")
+
+	lower := strings.ToLower(token)
+	for _, hostname := range InstagramHostnames {
+		if strings.HasPrefix(lower, hostname+"/") {
+			return "https://" + token
+		}
+	}
+	return token
+}
+
+// canonicalLinkKey maps the different forms of a link to the same thing onto
+// a single key, e.g. /p/<code> and /reel/<code>.
+func canonicalLinkKey(link string) string {
+	parsedURL, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	if code := LinkShortcode(link); code != "" {
+		return "media:" + code
+	}
+
+	segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	if len(segments) >= 2 && segments[0] == "stories" {
+		return "story:" + strings.Join(segments[1:], "/")
+	}
+
+	return "profile:" + strings.ToLower(strings.Join(segments, "/"))
+}
+
+// LinkShortcode returns the shortcode of a post, reel or IGTV link, or an
+// empty string for other links.
+func LinkShortcode(link string) string {
+	parsedURL, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	if len(segments) < 2 {
+		return ""
+	}
+
+	switch segments[0] {
+	case "p", "reel", "reels", "tv":
+		return segments[1]
+	}
+	return ""
+}
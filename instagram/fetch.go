@@ -0,0 +1,231 @@
+package instagram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	InstagramUserFeedURL  = "https://www.instagram.com/api/v1/feed/user/%s/username/?count=%d"
+	InstagramFeedPageSize = 12
+)
+
+// ErrNotFound is returned for links to posts or profiles that do not exist,
+// like deleted ones.
+var ErrNotFound = errors.New("Instagram has nothing at this link, it may have been deleted")
+
+// InstagramPost is the platform independent result of looking up a post, reel
+// or IGTV link.
+type InstagramPost struct {
+	ID           string
+	Code         string
+	MediaType    int
+	Caption      string
+	Owner        string
+	TakenAt      time.Time
+	DownloadPath string
+	IsStory      bool
+	Media        []MediaSource
+	Raw          []byte
+
+	// The caption as written by the owner, without the counts
+	CaptionText   string
+	CaptionEdited bool
+}
+
+// fetchJSON requests the JSON version of an Instagram page using the
+// configured session. Every attempt waits for its turn with the pacer.
+func fetchJSON(link string) ([]byte, error) {
+	ctx := context.Background()
+
+	var body []byte
+	err := Retry(ctx, apiTimeout(), IsRetryable, func(attemptCtx context.Context) error {
+		if err := WaitForAPI(ctx); err != nil {
+			return err
+		}
+
+		req, _ := http.NewRequestWithContext(attemptCtx, "GET", link, nil)
+
+		AddCookies(req)
+		AddHeaders(req)
+		AddQueries(req)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("Could not get JSON data:\n\n%w", err)
+		}
+		defer res.Body.Close()
+		SaveCookies(res)
+
+		if isRetryableStatus(res.StatusCode) {
+			return StatusError(res)
+		} else if res.StatusCode == http.StatusNotFound {
+			RecordAPIResult("")
+			return ErrNotFound
+		}
+
+		body, err = io.ReadAll(res.Body)
+		if err != nil {
+			return fmt.Errorf("Could not read response body:\n\n%w", err)
+		}
+
+		failure := ClassifyAPIResponse(res, body)
+		RecordAPIResult(failure)
+		if failure != "" {
+			return fmt.Errorf("Instagram refused the request: %s", failure)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func FetchPost(link string) (*InstagramPost, error) {
+	body, err := fetchJSON(link)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePost(body)
+}
+
+func ParsePost(body []byte) (*InstagramPost, error) {
+	mediaType := GetMediaType(body)
+	post := &InstagramPost{MediaType: mediaType, Raw: body}
+
+	switch mediaType {
+
+	case MediaTypeCarousel:
+		var ic InstagramCarousel
+		err := json.Unmarshal(body, &ic)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse body into InstagramCarousel:\n\n%s", err.Error())
+		}
+
+		item := ic.Items[0]
+		post.ID, post.Code, post.Caption = item.ID, item.Code, ic.Caption()
+		post.CaptionText, post.CaptionEdited = item.Caption.Text, item.IsCaptionEdited
+		post.Owner, post.TakenAt, post.DownloadPath = item.User.Username, takenAt(item.TakenAt), ic.DownloadPath()
+		for _, slide := range item.CarouselMedia {
+			post.Media = append(post.Media, slide.MediaSource())
+		}
+
+	case MediaTypeVideo:
+		var ir InstagramReel
+		err := json.Unmarshal(body, &ir)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse body into InstagramReel:\n\n%s", err.Error())
+		}
+
+		item := ir.Items[0]
+		post.ID, post.Code, post.Caption = item.ID, item.Code, ir.Caption()
+		post.CaptionText, post.CaptionEdited = item.Caption.Text, item.IsCaptionEdited
+		post.Owner, post.TakenAt, post.DownloadPath = item.User.Username, takenAt(item.TakenAt), ir.DownloadPath()
+		post.Media = []MediaSource{ir.MediaSource()}
+
+	case MediaTypeImage:
+		var ii InstagramImage
+		err := json.Unmarshal(body, &ii)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse body into InstagramImage:\n\n%s", err.Error())
+		}
+
+		item := ii.Items[0]
+		post.ID, post.Code, post.Caption = item.ID, item.Code, ii.Caption()
+		post.CaptionText, post.CaptionEdited = item.Caption.Text, item.IsCaptionEdited
+		post.Owner, post.TakenAt, post.DownloadPath = item.User.Username, takenAt(item.TakenAt), ii.DownloadPath()
+		post.Media = []MediaSource{ii.MediaSource()}
+
+	default:
+		return nil, fmt.Errorf("Unkown media type:\n\n[%v]", mediaType)
+
+	}
+
+	return post, nil
+}
+
+func takenAt(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+// FetchUserFeed returns the raw items of the user's latest posts, newest first.
+func FetchUserFeed(username string) ([]json.RawMessage, error) {
+	items, _, err := FetchUserFeedPage(username, "")
+	return items, err
+}
+
+// FetchUserFeedPage returns a page of the user's posts, newest first, starting
+// after the cursor maxID or at the newest post if it is empty. The cursor of
+// the next page is empty when there are no more posts.
+func FetchUserFeedPage(username, maxID string) ([]json.RawMessage, string, error) {
+	link := fmt.Sprintf(InstagramUserFeedURL, url.PathEscape(username), InstagramFeedPageSize)
+	if maxID != "" {
+		link += "&max_id=" + url.QueryEscape(maxID)
+	}
+
+	body, err := fetchJSON(link)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var feed struct {
+		Items         []json.RawMessage `json:"items"`
+		MoreAvailable bool              `json:"more_available"`
+		NextMaxID     string            `json:"next_max_id"`
+	}
+	err = json.Unmarshal(body, &feed)
+	if err != nil {
+		return nil, "", fmt.Errorf("Could not parse body into user feed:\n\n%s", err.Error())
+	}
+
+	if !feed.MoreAvailable || feed.NextMaxID == maxID {
+		feed.NextMaxID = ""
+	}
+	return feed.Items, feed.NextMaxID, nil
+}
+
+// ParseFeedItem parses a single item of a feed the same way as a post.
+func ParseFeedItem(item json.RawMessage) (*InstagramPost, error) {
+	body := append([]byte(`{"items":[`), item...)
+	return ParsePost(append(body, "]}"...))
+}
+
+// FetchUserProfile looks up a profile link, returning an error if the link
+// does not belong to a user.
+func FetchUserProfile(link string) (*InstagramUserProfile, error) {
+	body, err := fetchJSON(link)
+	if err != nil {
+		return nil, err
+	}
+
+	var iup InstagramUserProfile
+	err = json.Unmarshal(body, &iup)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse body into InstagramUserProfile:\n\n%s", err.Error())
+	}
+
+	if iup.Graphql.User.ID == "" {
+		return nil, fmt.Errorf("not a user profile")
+	}
+
+	return &iup, nil
+}
+
+func (iup InstagramUserProfile) MediaSource() MediaSource {
+	return MediaSource{
+		MediaType: MediaTypeImage,
+		Resolve:   func() (string, *MP4Info) { return iup.ProfilePicURLHD(), nil },
+	}
+}
@@ -2,27 +2,22 @@ package instagram
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
-	"time"
 
+	"modules-watgbridge/instagram/history"
 	"watgbridge/modules"
 	"watgbridge/state"
-	"watgbridge/utils"
 
-	"go.mau.fi/whatsmeow"
-	waProto "go.mau.fi/whatsmeow/binary/proto"
 	waTypes "go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
-	"golang.org/x/exp/slices"
-	"google.golang.org/protobuf/proto"
 )
 
 func InstagramModuleWhatsAppEventHandler(evt interface{}) {
 	switch v := evt.(type) {
+	case *events.Connected:
+		registerTelegramHandler()
+		startScheduler()
+
 	case *events.Message:
 		if v.Info.Timestamp.UTC().Before(state.State.StartTime) {
 			// Old events
@@ -36,328 +31,180 @@ func InstagramModuleWhatsAppEventHandler(evt interface{}) {
 			chat = v.Info.Chat
 		}
 
-		if v.Info.Chat.String() == "status@broadcast" ||
-			(!v.Info.IsFromMe && v.Info.IsGroup && !v.Info.IsIncomingBroadcast() && !slices.Contains(instaConfig.WhatsAppAllowedGroups, chat.User)) {
+		if v.Info.Chat.String() == "status@broadcast" {
 			return
 		}
 
-		text := ""
-		if extendedMessageText := v.Message.GetExtendedTextMessage().GetText(); extendedMessageText != "" {
-			text = extendedMessageText
-		} else {
-			text = v.Message.GetConversation()
+		policy := EffectivePolicy(v, chat)
+		texts := MessageTexts(v.Message, false)
+		if len(texts) > 0 && handleCommand(texts[0], v, chat, policy) {
+			return
+		}
+		if !policy.Allowed() {
+			return
 		}
 
-		if text == "" {
+		if len(texts) > 0 && handleSheetReply(texts[0], v, chat, policy) {
 			return
 		}
 
-		textSplit := strings.Fields(text)
-		for _, token := range textSplit {
-			if IsSupportedLink(token) && !IsStoriesLink(token) {
-				downloadLink(token, v, chat)
-			} else if IsInstagramLink(token) {
-				tryUserProfile(token, v, chat)
-			}
+		if policy.Mode == ChatModeAuto && !IsPaused() {
+			processLinks(ExtractLinks(MessageTexts(v.Message, true)...), v, chat, policy, false)
 		}
 	}
 }
 
-func downloadLink(link string, v *events.Message, chat waTypes.JID) {
-	waClient := state.State.WhatsAppClient
-
-	req, _ := http.NewRequest("GET", link, nil)
-
-	AddCookies(req)
-	AddHeaders(req)
-	AddQueries(req)
-
-	res, err := client.Do(req)
-	if err != nil {
-		utils.WaSendText(chat, fmt.Sprintf("Could not get JSON data:\n\n%s",
-			err.Error()), v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
-			v.Message, true)
-		return
-	}
-	defer res.Body.Close()
-	SaveCookies(res)
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		utils.WaSendText(chat, fmt.Sprintf("Could not read response body:\n\n%s",
-			err.Error()), v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
-			v.Message, true)
-		return
-	}
-
-	mediaType := GetMediaType(body)
-
-	switch mediaType {
-
-	case MediaTypeCarousel:
-		var ic InstagramCarousel
-		err := json.Unmarshal(body, &ic)
-		if err != nil {
-			utils.WaSendText(chat, fmt.Sprintf("Could not parse body into InstagramCarousel:\n\n%s",
-				err.Error()), v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
-				v.Message, true)
-			return
-		}
-
-		var (
-			caption           = ic.Caption()
-			successfulUploads = 0
-		)
-
-		for _, item := range ic.Items[0].CarouselMedia {
-			mediaLink := item.DownloadLink()
-
-			req, _ := http.NewRequest("GET", mediaLink, nil)
-			itemBytes, err := DownloadFile(req)
-			if err != nil {
+// processLinks handles every link in links that the policy allows, and
+// reports whether any of them was an Instagram link.
+// Links shared in the chat recently are skipped unless force is set.
+func processLinks(links []string, v *events.Message, chat waTypes.JID, policy PolicyRule, force bool) bool {
+	found := false
+	for _, link := range links {
+		feature := LinkFeature(link)
+		if feature == "" {
+			continue
+		}
+		found = true
+
+		if !policy.AllowsFeature(feature) {
+			replyText(v, chat, fmt.Sprintf("Downloading *%s* is not allowed here", feature))
+			continue
+		}
+		if !force {
+			if earlier := FindEarlierShare(chat.String(), ShortcodeToMediaID(LinkShortcode(link))); earlier != nil {
+				replyAlreadyShared(v, chat, earlier)
 				continue
 			}
-
-			if item.MediaType == MediaTypeImage {
-				uploadedImage, err := waClient.Upload(context.Background(), itemBytes, whatsmeow.MediaImage)
-				if err != nil {
-					continue
-				}
-
-				msgToSend := &waProto.Message{
-					ImageMessage: &waProto.ImageMessage{
-						Url:               proto.String(uploadedImage.URL),
-						DirectPath:        proto.String(uploadedImage.DirectPath),
-						MediaKey:          uploadedImage.MediaKey,
-						MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
-						Mimetype:          proto.String(http.DetectContentType(itemBytes)),
-						FileEncSha256:     uploadedImage.FileEncSHA256,
-						FileSha256:        uploadedImage.FileSHA256,
-						FileLength:        proto.Uint64(uint64(len(itemBytes))),
-						Height:            proto.Uint32(uint32(item.Height)),
-						Width:             proto.Uint32(uint32(item.Width)),
-						ContextInfo: &waProto.ContextInfo{
-							StanzaId:      proto.String(v.Info.ID),
-							Participant:   proto.String(v.Info.MessageSource.Sender.ToNonAD().String()),
-							QuotedMessage: v.Message,
-						},
-					},
-				}
-
-				_, err = waClient.SendMessage(context.Background(), chat, msgToSend)
-				if err == nil {
-					successfulUploads += 1
-				}
-			} else if mediaType == MediaTypeVideo {
-				uploadedVideo, err := waClient.Upload(context.Background(), itemBytes, whatsmeow.MediaVideo)
-				if err != nil {
-					continue
-				}
-
-				msgToSend := &waProto.Message{
-					VideoMessage: &waProto.VideoMessage{
-						Url:           proto.String(uploadedVideo.URL),
-						DirectPath:    proto.String(uploadedVideo.DirectPath),
-						MediaKey:      uploadedVideo.MediaKey,
-						Mimetype:      proto.String(http.DetectContentType(itemBytes)),
-						FileEncSha256: uploadedVideo.FileEncSHA256,
-						FileSha256:    uploadedVideo.FileSHA256,
-						FileLength:    proto.Uint64(uint64(len(itemBytes))),
-						Seconds:       proto.Uint32(uint32(item.VideoDuration)),
-						GifPlayback:   proto.Bool(false),
-						Height:        proto.Uint32(uint32(item.Height)),
-						Width:         proto.Uint32(uint32(item.Width)),
-						ContextInfo: &waProto.ContextInfo{
-							StanzaId:      proto.String(v.Info.ID),
-							Participant:   proto.String(v.Info.MessageSource.Sender.ToNonAD().String()),
-							QuotedMessage: v.Message,
-						},
-					},
-				}
-
-				_, err = waClient.SendMessage(context.Background(), chat, msgToSend)
-				if err == nil {
-					successfulUploads += 1
-				}
-			}
 		}
-
-		if successfulUploads > 0 {
-			utils.WaSendText(chat, caption, v.Info.ID, v.Info.MessageSource.Sender.
-				ToNonAD().String(), v.Message, true)
-			return
+		reservation := reserveDownload(v, chat, policy)
+		if reservation == nil {
+			return found
 		}
 
-	case MediaTypeVideo:
-		var ir InstagramReel
-		err := json.Unmarshal(body, &ir)
-		if err != nil {
-			utils.WaSendText(chat, fmt.Sprintf("Could not parse body into InstagramReel:\n\n%s",
-				err.Error()), v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
-				v.Message, true)
-			return
-		}
-
-		var (
-			caption   = ir.Caption()
-			mediaLink = ir.DownloadLink()
-		)
+		job := NewDownloadJob()
 
-		req, _ := http.NewRequest("GET", mediaLink, nil)
-		videoBytes, err := DownloadFile(req)
-		if err != nil {
-			return
-		}
-
-		uploadedVideo, err := waClient.Upload(context.Background(), videoBytes, whatsmeow.MediaVideo)
-		if err != nil {
-			return
+		var items int
+		if IsSupportedLink(link) && !IsStoriesLink(link) {
+			items = downloadLink(job, link, v, chat)
+		} else {
+			items = tryUserProfile(job, link, v, chat)
 		}
 
-		msgToSend := &waProto.Message{
-			VideoMessage: &waProto.VideoMessage{
-				Caption:       proto.String(caption),
-				Url:           proto.String(uploadedVideo.URL),
-				DirectPath:    proto.String(uploadedVideo.DirectPath),
-				MediaKey:      uploadedVideo.MediaKey,
-				Mimetype:      proto.String(http.DetectContentType(videoBytes)),
-				FileEncSha256: uploadedVideo.FileEncSHA256,
-				FileSha256:    uploadedVideo.FileSHA256,
-				FileLength:    proto.Uint64(uint64(len(videoBytes))),
-				Seconds:       proto.Uint32(uint32(ir.Items[0].VideoDuration)),
-				GifPlayback:   proto.Bool(false),
-				Height:        proto.Uint32(uint32(ir.Items[0].Height)),
-				Width:         proto.Uint32(uint32(ir.Items[0].Width)),
-				ContextInfo: &waProto.ContextInfo{
-					StanzaId:      proto.String(v.Info.ID),
-					Participant:   proto.String(v.Info.MessageSource.Sender.ToNonAD().String()),
-					QuotedMessage: v.Message,
-				},
-			},
-		}
+		reservation.Settle(items, job.TotalSize())
+		job.Cleanup()
+	}
 
-		waClient.SendMessage(context.Background(), chat, msgToSend)
+	return found
+}
 
-	case MediaTypeImage:
-		var ii InstagramImage
-		err := json.Unmarshal(body, &ii)
-		if err != nil {
-			utils.WaSendText(chat, fmt.Sprintf("Could not parse body into InstagramImage:\n\n%s",
-				err.Error()), v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
-				v.Message, true)
-			return
+// reserveDownload reserves an item of the sender's quota and takes a rate
+// limit token before a download, and replies with when to try again if either
+// is used up. The reservation is nil then, and has to be settled otherwise.
+func reserveDownload(v *events.Message, chat waTypes.JID, policy PolicyRule) *QuotaReservation {
+	reservation, wait := ReserveQuota(quotaKey(chat, v.Info.MessageSource.Sender), policy.Quota)
+	if reservation == nil {
+		replyText(v, chat, fmt.Sprintf("You have used up today's download quota, try again in %s",
+			FormatWait(wait)))
+		return nil
+	}
+	if !v.Info.IsFromMe {
+		if allowed, wait := TakeRateLimitToken(chat, v.Info.MessageSource.Sender); !allowed {
+			reservation.Settle(0, 0)
+			replyText(v, chat, fmt.Sprintf("Too many links, try again in %s", FormatWait(wait)))
+			return nil
 		}
+	}
+	return reservation
+}
 
-		var (
-			caption   = ii.Caption()
-			mediaLink = ii.DownloadLink()
-		)
+// downloadLink sends the media of a post, reel or IGTV link and returns how
+// many items were delivered.
+func downloadLink(job *DownloadJob, link string, v *events.Message, chat waTypes.JID) int {
+	entry := newHistoryEntry(link, v, chat)
+	defer RecordHistory(entry)
 
-		req, _ := http.NewRequest("GET", mediaLink, nil)
-		imageBytes, err := DownloadFile(req)
-		if err != nil {
-			return
-		}
+	post, err := FetchPost(link)
+	if err != nil {
+		replyText(v, chat, err.Error())
+		return 0
+	}
 
-		uploadedImage, err := waClient.Upload(context.Background(), imageBytes, whatsmeow.MediaImage)
-		if err != nil {
-			return
-		}
+	return deliverPost(job, post, v, chat, entry)
+}
 
-		msgToSend := &waProto.Message{
-			ImageMessage: &waProto.ImageMessage{
-				Caption:           proto.String(caption),
-				Url:               proto.String(uploadedImage.URL),
-				DirectPath:        proto.String(uploadedImage.DirectPath),
-				MediaKey:          uploadedImage.MediaKey,
-				MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
-				Mimetype:          proto.String(http.DetectContentType(imageBytes)),
-				FileEncSha256:     uploadedImage.FileEncSHA256,
-				FileSha256:        uploadedImage.FileSHA256,
-				FileLength:        proto.Uint64(uint64(len(imageBytes))),
-				Height:            proto.Uint32(uint32(ii.Items[0].Height)),
-				Width:             proto.Uint32(uint32(ii.Items[0].Width)),
-				ContextInfo: &waProto.ContextInfo{
-					StanzaId:      proto.String(v.Info.ID),
-					Participant:   proto.String(v.Info.MessageSource.Sender.ToNonAD().String()),
-					QuotedMessage: v.Message,
-				},
-			},
-		}
+// deliverPost sends a fetched post to chat and returns how many items were
+// delivered. v is the message that asked for the post, or nil when there is
+// none like for subscriptions.
+func deliverPost(job *DownloadJob, post *InstagramPost, v *events.Message, chat waTypes.JID,
+	entry *history.Entry) int {
 
-		waClient.SendMessage(context.Background(), chat, msgToSend)
+	setHistoryPost(entry, post)
+	defer func() { TrackSharedMedia(post, entry) }()
+	job.KeepFiles = keepsPostFiles(post)
 
-	default:
-		utils.WaSendText(chat, fmt.Sprintf("Unkown media type:\n\n[%v]",
-			mediaType), v.Info.ID, v.Info.MessageSource.Sender.ToNonAD().String(),
-			v.Message, true)
-		return
+	if post.MediaType == MediaTypeCarousel {
+		if instaConfig.Carousel.ContactSheet && len(post.Media) > 1 {
+			items := sendCarouselSheet(job, post, v, chat)
+			entry.SetItems(items, 1)
+			return items
+		}
 
+		items := sendCarousel(job, post, v, chat)
+		entry.SetItems(items, len(post.Media))
+		return items
 	}
-}
-
-func tryUserProfile(link string, v *events.Message, chat waTypes.JID) {
-	waClient := state.State.WhatsAppClient
 
-	req, _ := http.NewRequest("GET", link, nil)
-	AddCookies(req)
-	AddHeaders(req)
-	AddQueries(req)
-
-	res, err := client.Do(req)
+	media, err := PrepareMedia(context.Background(), job, post.Media[0], post.Caption, v)
 	if err != nil {
-		return
+		replyText(v, chat, fmt.Sprintf("Could not download the media:\n\n%s", err.Error()))
+		return 0
 	}
-	defer res.Body.Close()
-	SaveCookies(res)
+	ArchivePost(post, []*DownloadedFile{media.File}, archiveRequestFor(v, chat))
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return
+	if media.TooLarge {
+		OverflowToTelegram(v, chat, post.Caption, []*PreparedMedia{media})
+		entry.SetItems(1, 1)
+		entry.Outcome = history.Overflow
+		return 1
 	}
 
-	var iup InstagramUserProfile
-	err = json.Unmarshal(body, &iup)
+	_, err = sendWhatsAppMessage(chat, media.Message)
 	if err != nil {
-		return
-	}
-
-	if iup.Graphql.User.ID == "" {
-		return
+		return 0
 	}
+	entry.SetItems(1, 1)
+	MirrorToTelegram(job, chat, post.Caption, []*PreparedMedia{media})
+	return 1
+}
 
-	dpLink := iup.ProfilePicURLHD()
-	req, _ = http.NewRequest("GET", dpLink, nil)
-	dpBytes, err := DownloadFile(req)
+// tryUserProfile sends the profile picture and details of a profile link, and
+// returns how many items were delivered.
+func tryUserProfile(job *DownloadJob, link string, v *events.Message, chat waTypes.JID) int {
+	iup, err := FetchUserProfile(link)
 	if err != nil {
-		return
+		replyText(v, chat, err.Error())
+		return 0
 	}
 
-	uploadedImage, err := waClient.Upload(context.Background(), dpBytes, whatsmeow.MediaImage)
+	caption := iup.Caption()
+	media, err := PrepareMedia(context.Background(), job, iup.MediaSource(), caption, v)
 	if err != nil {
-		return
+		replyText(v, chat, fmt.Sprintf("Could not download the media:\n\n%s", err.Error()))
+		return 0
 	}
 
-	msgToSend := &waProto.Message{
-		ImageMessage: &waProto.ImageMessage{
-			Caption:           proto.String(iup.Caption()),
-			Url:               proto.String(uploadedImage.URL),
-			DirectPath:        proto.String(uploadedImage.DirectPath),
-			MediaKey:          uploadedImage.MediaKey,
-			MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
-			Mimetype:          proto.String(http.DetectContentType(dpBytes)),
-			FileEncSha256:     uploadedImage.FileEncSHA256,
-			FileSha256:        uploadedImage.FileSHA256,
-			FileLength:        proto.Uint64(uint64(len(dpBytes))),
-			ContextInfo: &waProto.ContextInfo{
-				StanzaId:      proto.String(v.Info.ID),
-				Participant:   proto.String(v.Info.MessageSource.Sender.ToNonAD().String()),
-				QuotedMessage: v.Message,
-			},
-		},
+	if media.TooLarge {
+		OverflowToTelegram(v, chat, caption, []*PreparedMedia{media})
+		return 1
 	}
 
-	waClient.SendMessage(context.Background(), chat, msgToSend)
+	_, err = sendWhatsAppMessage(chat, media.Message)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not send the media:\n\n%s", err.Error()))
+		return 0
+	}
+	MirrorToTelegram(job, chat, caption, []*PreparedMedia{media})
+	return 1
 }
 
 func init() {
@@ -0,0 +1,82 @@
+package instagram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"modules-watgbridge/instagram/history"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func newHistoryEntry(link string, v *events.Message, chat waTypes.JID) *history.Entry {
+	if v == nil {
+		return &history.Entry{
+			Link:       link,
+			Chat:       chat.String(),
+			SenderName: history.Subscription,
+			Outcome:    history.Failed,
+		}
+	}
+	return &history.Entry{
+		Link:       link,
+		Chat:       chat.String(),
+		Sender:     v.Info.MessageSource.Sender.ToNonAD().String(),
+		SenderName: v.Info.PushName,
+		MessageID:  v.Info.ID,
+		Outcome:    history.Failed,
+	}
+}
+
+func setHistoryPost(entry *history.Entry, post *InstagramPost) {
+	entry.MediaID, entry.Shortcode, entry.Owner = CanonicalMediaID(post.ID), post.Code, post.Owner
+	entry.Caption = post.Caption
+}
+
+func RecordHistory(entry *history.Entry) error {
+	db, err := getDatabase()
+	if err != nil {
+		return err
+	}
+	return db.Create(entry).Error
+}
+
+// ParseHistoryFilter reads the arguments of .ighistory, which are an @user, a
+// date or date range like 2023-01-01..2023-01-31, or else a keyword.
+func ParseHistoryFilter(args []string) (history.Filter, error) {
+	var filter history.Filter
+
+	query := strings.TrimSpace(strings.Join(args, " "))
+	switch {
+	case query == "":
+	case strings.HasPrefix(query, "@"):
+		filter.Owner = strings.TrimPrefix(query, "@")
+	case len(query) >= len(history.DateLayout) && isHistoryDate(query[:len(history.DateLayout)]):
+		from, to, _ := strings.Cut(query, "..")
+		if to == "" {
+			to = from
+		}
+
+		location := quotaNow().Location()
+		start, err := time.ParseInLocation(history.DateLayout, from, location)
+		if err != nil {
+			return filter, fmt.Errorf("could not parse date '%s'", from)
+		}
+		end, err := time.ParseInLocation(history.DateLayout, to, location)
+		if err != nil {
+			return filter, fmt.Errorf("could not parse date '%s'", to)
+		}
+		filter.From, filter.To = start, end.AddDate(0, 0, 1)
+	default:
+		filter.Keyword = query
+	}
+
+	return filter, nil
+}
+
+func isHistoryDate(value string) bool {
+	_, err := time.Parse(history.DateLayout, value)
+	return err == nil
+}
@@ -0,0 +1,136 @@
+// Package history is the record of the links handled by the Instagram module.
+// It does not depend on the bridge, so that tools like instagram-history can
+// read the record straight from the database.
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	Sent     = "sent"
+	Partial  = "partial"
+	Overflow = "overflow"
+	Failed   = "failed"
+
+	DateLayout = "2006-01-02"
+)
+
+// Subscription is the sender name of media sent for subscriptions
+const Subscription = "subscription"
+
+// Entry is a link that was handled for a WhatsApp message.
+type Entry struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	MediaID    string    `gorm:"index" json:"media_id"`
+	Shortcode  string    `gorm:"index" json:"shortcode"`
+	Owner      string    `gorm:"index" json:"owner"`
+	Caption    string    `json:"caption"`
+	Link       string    `json:"link"`
+	Chat       string    `gorm:"index" json:"chat"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	MessageID  string    `json:"message_id"`
+	Outcome    string    `json:"outcome"`
+	Items      int       `json:"items"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+func (Entry) TableName() string {
+	return "instagram_history"
+}
+
+// SetItems sets the outcome from how many of the total items were delivered.
+func (entry *Entry) SetItems(items, total int) {
+	entry.Items = items
+	switch {
+	case items == 0:
+		entry.Outcome = Failed
+	case items < total:
+		entry.Outcome = Partial
+	default:
+		entry.Outcome = Sent
+	}
+}
+
+// Filter narrows down history entries. Zero values match everything.
+type Filter struct {
+	Chat    string
+	Owner   string
+	Keyword string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+func (filter Filter) apply(db *gorm.DB) *gorm.DB {
+	if filter.Chat != "" {
+		db = db.Where("chat = ?", filter.Chat)
+	}
+	if filter.Owner != "" {
+		db = db.Where("LOWER(owner) = ?", strings.ToLower(filter.Owner))
+	}
+	if filter.Keyword != "" {
+		keyword := "%" + strings.ToLower(filter.Keyword) + "%"
+		db = db.Where("LOWER(caption) LIKE ? OR LOWER(shortcode) LIKE ? OR LOWER(owner) LIKE ? OR LOWER(sender_name) LIKE ?",
+			keyword, keyword, keyword, keyword)
+	}
+	if !filter.From.IsZero() {
+		db = db.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		db = db.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+	return db.Order("created_at DESC")
+}
+
+// Find returns the newest entries matching the filter.
+func Find(db *gorm.DB, filter Filter) ([]Entry, error) {
+	var entries []Entry
+	err := filter.apply(db).Find(&entries).Error
+	return entries, err
+}
+
+// Export writes the entries matching the filter as "csv" or "json".
+func Export(db *gorm.DB, w io.Writer, format string, filter Filter) error {
+	entries, err := Find(db, filter)
+	if err != nil {
+		return fmt.Errorf("could not query history : %s", err)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"id", "created_at", "media_id", "shortcode", "owner", "chat",
+			"sender", "sender_name", "message_id", "link", "outcome", "items", "caption"})
+		for _, entry := range entries {
+			writer.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10), entry.CreatedAt.Format(time.RFC3339),
+				entry.MediaID, entry.Shortcode, entry.Owner, entry.Chat, entry.Sender,
+				entry.SenderName, entry.MessageID, entry.Link, entry.Outcome,
+				strconv.Itoa(entry.Items), entry.Caption,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("unknown export format '%s'", format)
+	}
+}
@@ -0,0 +1,399 @@
+package instagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"watgbridge/state"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// WhatsApp does not take media files larger than this
+const WhatsAppUploadLimit = 100 * 1024 * 1024
+
+// Files are read into memory for uploading, so only this many are uploaded at
+// the same time
+const MaxConcurrentUploads = 2
+
+var ErrUploadLimit = errors.New("file is larger than WhatsApp takes")
+
+var uploadSlots = make(chan struct{}, MaxConcurrentUploads)
+
+type MediaMeta struct {
+	Caption   string
+	Thumbnail []byte
+	Seconds   uint32
+	Width     int32
+	Height    int32
+}
+
+func ImageMediaMeta(file *DownloadedFile, caption string, width, height int32) MediaMeta {
+	meta := MediaMeta{Caption: caption, Width: width, Height: height}
+
+	thumbnail, decodedWidth, decodedHeight, err := MakeThumbnail(file.Path)
+	if err != nil {
+		return meta
+	}
+
+	meta.Thumbnail = thumbnail
+	if meta.Width <= 0 || meta.Height <= 0 {
+		meta.Width, meta.Height = decodedWidth, decodedHeight
+	}
+	return meta
+}
+
+func VideoMediaMeta(job *DownloadJob, covers []ImageVersion, caption string,
+	width, height int32, duration float64, info *MP4Info) MediaMeta {
+
+	meta := MediaMeta{
+		Caption: caption,
+		Seconds: uint32(duration),
+		Width:   width,
+		Height:  height,
+	}
+	if info != nil {
+		if info.Duration > 0 {
+			meta.Seconds = info.Seconds()
+		}
+		if meta.Width <= 0 || meta.Height <= 0 {
+			meta.Width, meta.Height = info.Width, info.Height
+		}
+	}
+
+	thumbnail, decodedWidth, decodedHeight, err := CoverThumbnail(job, covers...)
+	if err != nil {
+		return meta
+	}
+
+	meta.Thumbnail = thumbnail
+	if meta.Width <= 0 || meta.Height <= 0 {
+		meta.Width, meta.Height = decodedWidth, decodedHeight
+	}
+	return meta
+}
+
+func replyContextInfo(v *events.Message) *waProto.ContextInfo {
+	if v == nil {
+		return nil
+	}
+	return &waProto.ContextInfo{
+		StanzaId:      proto.String(v.Info.ID),
+		Participant:   proto.String(v.Info.MessageSource.Sender.ToNonAD().String()),
+		QuotedMessage: v.Message,
+	}
+}
+
+func NewImageMessage(uploaded whatsmeow.UploadResponse, file *DownloadedFile,
+	meta MediaMeta, v *events.Message) *waProto.Message {
+
+	msg := &waProto.ImageMessage{
+		Url:               proto.String(uploaded.URL),
+		DirectPath:        proto.String(uploaded.DirectPath),
+		MediaKey:          uploaded.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		Mimetype:          proto.String(file.Mimetype),
+		FileEncSha256:     uploaded.FileEncSHA256,
+		FileSha256:        uploaded.FileSHA256,
+		FileLength:        proto.Uint64(uint64(file.Size)),
+		JpegThumbnail:     meta.Thumbnail,
+		ContextInfo:       replyContextInfo(v),
+	}
+	if meta.Caption != "" {
+		msg.Caption = proto.String(meta.Caption)
+	}
+	if meta.Width > 0 && meta.Height > 0 {
+		msg.Width = proto.Uint32(uint32(meta.Width))
+		msg.Height = proto.Uint32(uint32(meta.Height))
+	}
+
+	return &waProto.Message{ImageMessage: msg}
+}
+
+func NewVideoMessage(uploaded whatsmeow.UploadResponse, file *DownloadedFile,
+	meta MediaMeta, v *events.Message) *waProto.Message {
+
+	msg := &waProto.VideoMessage{
+		Url:               proto.String(uploaded.URL),
+		DirectPath:        proto.String(uploaded.DirectPath),
+		MediaKey:          uploaded.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		Mimetype:          proto.String(file.Mimetype),
+		FileEncSha256:     uploaded.FileEncSHA256,
+		FileSha256:        uploaded.FileSHA256,
+		FileLength:        proto.Uint64(uint64(file.Size)),
+		Seconds:           proto.Uint32(meta.Seconds),
+		GifPlayback:       proto.Bool(false),
+		JpegThumbnail:     meta.Thumbnail,
+		ContextInfo:       replyContextInfo(v),
+	}
+	if meta.Caption != "" {
+		msg.Caption = proto.String(meta.Caption)
+	}
+	if meta.Width > 0 && meta.Height > 0 {
+		msg.Width = proto.Uint32(uint32(meta.Width))
+		msg.Height = proto.Uint32(uint32(meta.Height))
+	}
+
+	return &waProto.Message{VideoMessage: msg}
+}
+
+// MediaSource describes a single image or video that is to be sent. Resolve
+// picks the link to download from, and is only called when no earlier upload
+// of the media could be reused.
+type MediaSource struct {
+	MediaID   string
+	MediaType int
+	Resolve   func() (string, *MP4Info)
+	Covers    []ImageVersion
+	Duration  float64
+	Width     int32
+	Height    int32
+}
+
+func (ii InstagramImage) MediaSource() MediaSource {
+	item := ii.Items[0]
+	return MediaSource{
+		MediaID:   item.ID,
+		MediaType: MediaTypeImage,
+		Resolve:   func() (string, *MP4Info) { return ii.DownloadLink(), nil },
+		Width:     item.Width,
+		Height:    item.Height,
+	}
+}
+
+func (ir InstagramReel) MediaSource() MediaSource {
+	item := ir.Items[0]
+	return MediaSource{
+		MediaID:   item.ID,
+		MediaType: MediaTypeVideo,
+		Resolve:   ir.CompatibleDownloadLink,
+		Covers:    item.CoverCandidates(),
+		Duration:  item.VideoDuration,
+		Width:     item.Width,
+		Height:    item.Height,
+	}
+}
+
+// MediaSource returns the source of the story item with the given PK.
+func (is InstagramStory) MediaSource(pk int64) MediaSource {
+	for _, item := range is.Items {
+		if item.PK != pk {
+			continue
+		}
+
+		if item.MediaType != MediaTypeVideo {
+			return MediaSource{
+				MediaID:   item.ID,
+				MediaType: MediaTypeImage,
+				Resolve: func() (string, *MP4Info) {
+					return largestImageVersion(item.ImageVersions.Candidates).URL, nil
+				},
+				Width:  item.Width,
+				Height: item.Height,
+			}
+		}
+
+		return MediaSource{
+			MediaID:   item.ID,
+			MediaType: MediaTypeVideo,
+			Resolve: func() (string, *MP4Info) {
+				version, info := SelectVideoVersion(item.VideoVersions)
+				if info == nil {
+					return is.DownloadLink(pk), nil
+				}
+				return version.URL, info
+			},
+			Covers:   item.CoverCandidates(),
+			Duration: item.VideoDuration,
+			Width:    item.Width,
+			Height:   item.Height,
+		}
+	}
+	return MediaSource{}
+}
+
+func largestImageVersion(candidates []ImageVersion) ImageVersion {
+	var largest ImageVersion
+	for _, candidate := range candidates {
+		if candidate.Width*candidate.Height > largest.Width*largest.Height {
+			largest = candidate
+		}
+	}
+	return largest
+}
+
+func (cm CarouselMedia) MediaSource() MediaSource {
+	return MediaSource{
+		MediaID:   cm.ID,
+		MediaType: cm.MediaType,
+		Resolve:   cm.CompatibleDownloadLink,
+		Covers:    cm.CoverCandidates(),
+		Duration:  cm.VideoDuration,
+		Width:     cm.Width,
+		Height:    cm.Height,
+	}
+}
+
+func DownloadMedia(ctx context.Context, job *DownloadJob, src MediaSource) (*DownloadedFile, *MP4Info, error) {
+	mediaLink, videoInfo := src.Resolve()
+	if mediaLink == "" {
+		return nil, nil, fmt.Errorf("no download link found")
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", mediaLink, nil)
+	mediaFile, err := job.Download(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mediaFile, videoInfo, nil
+}
+
+// PreparedMedia is a message that is ready to be sent. File is nil when an
+// earlier upload of the same media was reused without downloading it again,
+// which never happens for jobs that keep their files.
+// Media that is too large for WhatsApp has no message and is only downloaded.
+type PreparedMedia struct {
+	Message  *waProto.Message
+	File     *DownloadedFile
+	Source   MediaSource
+	TooLarge bool
+}
+
+// PrepareMedia returns a message for src which is ready to be sent, reusing
+// an earlier upload of the same media or the same file whenever possible.
+func PrepareMedia(ctx context.Context, job *DownloadJob, src MediaSource, caption string,
+	v *events.Message) (*PreparedMedia, error) {
+
+	var whatsmeowType whatsmeow.MediaType
+	switch src.MediaType {
+	case MediaTypeImage:
+		whatsmeowType = whatsmeow.MediaImage
+	case MediaTypeVideo:
+		whatsmeowType = whatsmeow.MediaVideo
+	default:
+		return nil, fmt.Errorf("unknown media type [%v]", src.MediaType)
+	}
+
+	if cached := LookupUploadByMediaID(src.MediaID, src.MediaType); cached != nil && !job.KeepFiles {
+		return &PreparedMedia{
+			Message: newMediaMessage(src.MediaType, cached.UploadResponse(), cached.File(),
+				cached.Meta(caption), v),
+			Source: src,
+		}, nil
+	}
+
+	mediaFile, videoInfo, err := DownloadMedia(ctx, job, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached := LookupUploadBySHA256(mediaFile.SHA256, src.MediaType); cached != nil {
+		meta := cached.Meta(caption)
+		StoreUpload(src.MediaID, src.MediaType, mediaFile, cached.UploadResponse(), meta)
+		return &PreparedMedia{
+			Message: newMediaMessage(src.MediaType, cached.UploadResponse(), cached.File(), meta, v),
+			File:    mediaFile,
+			Source:  src,
+		}, nil
+	}
+
+	if IsTooLargeForWhatsApp(mediaFile) {
+		return &PreparedMedia{File: mediaFile, Source: src, TooLarge: true}, nil
+	}
+
+	uploaded, err := UploadFile(ctx, mediaFile, whatsmeowType)
+	if err != nil {
+		return nil, fmt.Errorf("could not upload the media : %w", err)
+	}
+
+	var meta MediaMeta
+	if src.MediaType == MediaTypeImage {
+		meta = ImageMediaMeta(mediaFile, caption, src.Width, src.Height)
+	} else {
+		meta = VideoMediaMeta(job, src.Covers, caption, src.Width, src.Height,
+			src.Duration, videoInfo)
+	}
+
+	StoreUpload(src.MediaID, src.MediaType, mediaFile, uploaded, meta)
+	return &PreparedMedia{
+		Message: newMediaMessage(src.MediaType, uploaded, mediaFile, meta, v),
+		File:    mediaFile,
+		Source:  src,
+	}, nil
+}
+
+func newMediaMessage(mediaType int, uploaded whatsmeow.UploadResponse, file *DownloadedFile,
+	meta MediaMeta, v *events.Message) *waProto.Message {
+
+	if mediaType == MediaTypeVideo {
+		return NewVideoMessage(uploaded, file, meta, v)
+	}
+	return NewImageMessage(uploaded, file, meta, v)
+}
+
+// SendImageFile uploads an image from disk and sends it to chat, as a reply to
+// v if it is not nil.
+func SendImageFile(v *events.Message, chat waTypes.JID, file *DownloadedFile,
+	caption string) (whatsmeow.SendResponse, error) {
+
+	uploaded, err := UploadFile(context.Background(), file, whatsmeow.MediaImage)
+	if err != nil {
+		return whatsmeow.SendResponse{}, fmt.Errorf("could not upload the image : %w", err)
+	}
+
+	return sendWhatsAppMessage(chat, NewImageMessage(uploaded, file,
+		ImageMediaMeta(file, caption, 0, 0), v))
+}
+
+// UploadFile uploads a file from disk to WhatsApp. whatsmeow can only upload
+// from memory, so the whole file is read first, which is why files larger than
+// WhatsApp takes are refused up front and uploads wait for a free slot.
+func UploadFile(ctx context.Context, file *DownloadedFile,
+	mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+
+	if file.Size > WhatsAppUploadLimit {
+		return whatsmeow.UploadResponse{}, ErrUploadLimit
+	}
+	return uploadWhatsAppMedia(ctx, file.Bytes, mediaType)
+}
+
+func uploadWhatsAppMedia(ctx context.Context, read func() ([]byte, error),
+	mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+
+	select {
+	case uploadSlots <- struct{}{}:
+		defer func() { <-uploadSlots }()
+	case <-ctx.Done():
+		return whatsmeow.UploadResponse{}, ctx.Err()
+	}
+
+	data, err := read()
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
+	}
+
+	var uploaded whatsmeow.UploadResponse
+	err = Retry(ctx, uploadTimeout(), IsRetryableUploadError, func(ctx context.Context) error {
+		var err error
+		uploaded, err = state.State.WhatsAppClient.Upload(ctx, data, mediaType)
+		return err
+	})
+	return uploaded, err
+}
+
+// sendWhatsAppMessage sends msg to chat. Sending is not retried, as a message
+// that timed out might still have been delivered.
+func sendWhatsAppMessage(chat waTypes.JID, msg *waProto.Message) (whatsmeow.SendResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout())
+	defer cancel()
+
+	return state.State.WhatsAppClient.SendMessage(ctx, chat, msg)
+}
@@ -0,0 +1,364 @@
+package instagram
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Number of bytes fetched from the start of a video while probing it
+	MP4ProbeSize = 256 * 1024
+	// The moov box is not fetched separately if it is bigger than this
+	MP4MaxMoovSize = 8 * 1024 * 1024
+	// Only this many top level boxes are looked at before giving up
+	mp4MaxTopLevelBoxes = 16
+)
+
+var ErrNoMoovBox = errors.New("could not find the moov box")
+
+type MP4Info struct {
+	VideoCodec string
+	AudioCodec string
+	Duration   float64
+	Width      int32
+	Height     int32
+	FastStart  bool
+}
+
+// IsWhatsAppCompatible tells if the video can be played by every WhatsApp
+// client, which requires H.264 video and AAC audio (if there is any audio).
+func (info *MP4Info) IsWhatsAppCompatible() bool {
+	isH264 := info.VideoCodec == "avc1" || info.VideoCodec == "avc3"
+	isAAC := info.AudioCodec == "" || info.AudioCodec == "mp4a"
+	return isH264 && isAAC
+}
+
+func (info *MP4Info) Seconds() uint32 {
+	return uint32(math.Round(info.Duration))
+}
+
+type mp4Box struct {
+	Type       string
+	Size       int64
+	HeaderSize int64
+}
+
+// readMP4BoxHeader parses the box header at the start of data. A size of zero
+// in the file means the box runs till the end of the file, which is reported
+// back as -1 since the total size may not be known here.
+func readMP4BoxHeader(data []byte) (mp4Box, error) {
+	if len(data) < 8 {
+		return mp4Box{}, io.ErrUnexpectedEOF
+	}
+
+	box := mp4Box{
+		Type:       string(data[4:8]),
+		Size:       int64(binary.BigEndian.Uint32(data[0:4])),
+		HeaderSize: 8,
+	}
+
+	switch box.Size {
+	case 0:
+		box.Size = -1
+	case 1:
+		if len(data) < 16 {
+			return mp4Box{}, io.ErrUnexpectedEOF
+		}
+		box.Size = int64(binary.BigEndian.Uint64(data[8:16]))
+		box.HeaderSize = 16
+	}
+
+	if box.Size != -1 && box.Size < box.HeaderSize {
+		return mp4Box{}, fmt.Errorf("invalid size %d for box '%s'", box.Size, box.Type)
+	}
+
+	return box, nil
+}
+
+// forEachMP4Box calls fn with the type and payload of every complete box
+// inside data.
+func forEachMP4Box(data []byte, fn func(boxType string, payload []byte) error) error {
+	for len(data) > 0 {
+		box, err := readMP4BoxHeader(data)
+		if err != nil {
+			return err
+		}
+		if box.Size == -1 {
+			box.Size = int64(len(data))
+		}
+		if box.Size > int64(len(data)) {
+			return io.ErrUnexpectedEOF
+		}
+
+		if err := fn(box.Type, data[box.HeaderSize:box.Size]); err != nil {
+			return err
+		}
+		data = data[box.Size:]
+	}
+	return nil
+}
+
+// ParseMP4Moov fills info from the payload of a moov box.
+func ParseMP4Moov(moov []byte, info *MP4Info) error {
+	return forEachMP4Box(moov, func(boxType string, payload []byte) error {
+		switch boxType {
+		case "mvhd":
+			info.Duration = parseMP4Duration(payload)
+		case "trak":
+			return parseMP4Track(payload, info)
+		}
+		return nil
+	})
+}
+
+func parseMP4Duration(mvhd []byte) float64 {
+	if len(mvhd) < 4 {
+		return 0
+	}
+
+	var timescale, duration uint64
+	if version := mvhd[0]; version == 1 {
+		if len(mvhd) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		if len(mvhd) < 20 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+func parseMP4Track(trak []byte, info *MP4Info) error {
+	var (
+		handler       string
+		codec         string
+		width, height int32
+	)
+
+	var walk func(data []byte) error
+	walk = func(data []byte) error {
+		return forEachMP4Box(data, func(boxType string, payload []byte) error {
+			switch boxType {
+			case "mdia", "minf", "stbl":
+				return walk(payload)
+			case "tkhd":
+				// Width and height are the last two 16.16 fixed point values
+				if len(payload) >= 8 {
+					tail := payload[len(payload)-8:]
+					width = int32(binary.BigEndian.Uint32(tail[0:4]) >> 16)
+					height = int32(binary.BigEndian.Uint32(tail[4:8]) >> 16)
+				}
+			case "hdlr":
+				if len(payload) >= 12 {
+					handler = string(payload[8:12])
+				}
+			case "stsd":
+				// Version, flags and entry count come before the first entry
+				if len(payload) >= 16 {
+					codec = string(payload[12:16])
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := walk(trak); err != nil {
+		return err
+	}
+
+	switch handler {
+	case "vide":
+		info.VideoCodec = codec
+		info.Width, info.Height = width, height
+	case "soun":
+		info.AudioCodec = codec
+	}
+	return nil
+}
+
+// fetchRange downloads length bytes of link starting at offset. It returns
+// whether the server honoured the range, along with the total size of the
+// file when the server reports it (-1 otherwise).
+func fetchRange(link string, offset, length int64) ([]byte, bool, int64, error) {
+	var (
+		data      []byte
+		isPartial bool
+		totalSize = int64(-1)
+	)
+
+	err := Retry(context.Background(), apiTimeout(), IsRetryable, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+		res, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("Error making request: %w", err)
+		}
+		defer res.Body.Close()
+
+		isPartial = res.StatusCode == http.StatusPartialContent
+		switch {
+		case isPartial:
+			contentRange := res.Header.Get("Content-Range")
+			if slash := strings.LastIndexByte(contentRange, '/'); slash != -1 {
+				if size, err := strconv.ParseInt(contentRange[slash+1:], 10, 64); err == nil {
+					totalSize = size
+				}
+			}
+		case res.StatusCode == http.StatusOK:
+			if offset != 0 {
+				return fmt.Errorf("server does not support range requests")
+			}
+			totalSize = res.ContentLength
+		default:
+			return StatusError(res)
+		}
+
+		data, err = io.ReadAll(io.LimitReader(res.Body, length))
+		return err
+	})
+	if err != nil {
+		return nil, false, -1, err
+	}
+	return data, isPartial, totalSize, nil
+}
+
+// ProbeMP4 inspects a remote MP4 file using range requests, without
+// downloading the media data itself.
+func ProbeMP4(link string) (*MP4Info, error) {
+	head, supportsRanges, totalSize, err := fetchRange(link, 0, MP4ProbeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		info     = &MP4Info{}
+		offset   = int64(0)
+		seenMdat = false
+	)
+
+	for i := 0; i < mp4MaxTopLevelBoxes; i++ {
+		if totalSize != -1 && offset >= totalSize {
+			break
+		}
+
+		var header []byte
+		if offset+16 <= int64(len(head)) {
+			header = head[offset : offset+16]
+		} else if offset < int64(len(head)) && int64(len(head)) == totalSize {
+			header = head[offset:]
+		} else if supportsRanges {
+			header, _, _, err = fetchRange(link, offset, 16)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			break
+		}
+
+		box, err := readMP4BoxHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if box.Size == -1 {
+			if totalSize == -1 {
+				break
+			}
+			box.Size = totalSize - offset
+		}
+
+		switch box.Type {
+		case "mdat":
+			seenMdat = true
+		case "moov":
+			var moov []byte
+			if offset+box.Size <= int64(len(head)) {
+				moov = head[offset+box.HeaderSize : offset+box.Size]
+			} else if supportsRanges && box.Size <= MP4MaxMoovSize {
+				moov, _, _, err = fetchRange(link, offset, box.Size)
+				if err != nil {
+					return nil, err
+				}
+				if int64(len(moov)) < box.Size {
+					return nil, io.ErrUnexpectedEOF
+				}
+				moov = moov[box.HeaderSize:]
+			} else {
+				return nil, ErrNoMoovBox
+			}
+
+			info.FastStart = !seenMdat
+			if err := ParseMP4Moov(moov, info); err != nil {
+				return nil, err
+			}
+			return info, nil
+		}
+
+		offset += box.Size
+	}
+
+	return nil, ErrNoMoovBox
+}
+
+// SelectVideoVersion probes the available versions from the highest
+// resolution down and returns the first one which WhatsApp can play,
+// preferring files with the moov box at the start. If no version could be
+// verified, the highest resolution one is returned without any info.
+func SelectVideoVersion(versions []VideoVersion) (VideoVersion, *MP4Info) {
+	ordered := make([]VideoVersion, 0, len(versions))
+	for _, version := range versions {
+		if version.URL != "" {
+			ordered = append(ordered, version)
+		}
+	}
+	if len(ordered) == 0 {
+		return VideoVersion{}, nil
+	}
+
+	// Stable so that Instagram's own ordering decides among equal resolutions
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Width*ordered[i].Height > ordered[j].Width*ordered[j].Height
+	})
+
+	var (
+		fallback     *VideoVersion
+		fallbackInfo *MP4Info
+	)
+	for i := range ordered {
+		info, err := ProbeMP4(ordered[i].URL)
+		if err != nil || !info.IsWhatsAppCompatible() {
+			continue
+		}
+		if info.FastStart {
+			return ordered[i], info
+		}
+		if fallback == nil {
+			fallback, fallbackInfo = &ordered[i], info
+		}
+	}
+
+	if fallback != nil {
+		return *fallback, fallbackInfo
+	}
+	return ordered[0], nil
+}
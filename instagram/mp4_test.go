@@ -0,0 +1,169 @@
+package instagram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func buildMP4Box(boxType string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(data, boxType...), payload...)
+}
+
+func buildLargeMP4Box(boxType string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	data := binary.BigEndian.AppendUint32(nil, 1)
+	data = append(data, boxType...)
+	data = binary.BigEndian.AppendUint64(data, uint64(16+len(payload)))
+	return append(data, payload...)
+}
+
+func mvhdV0(timescale, duration uint32) []byte {
+	data := make([]byte, 12, 100)
+	data = binary.BigEndian.AppendUint32(data, timescale)
+	data = binary.BigEndian.AppendUint32(data, duration)
+	return append(data, make([]byte, 80)...)
+}
+
+func mvhdV1(timescale uint32, duration uint64) []byte {
+	data := make([]byte, 20, 112)
+	data[0] = 1
+	data = binary.BigEndian.AppendUint32(data, timescale)
+	data = binary.BigEndian.AppendUint64(data, duration)
+	return append(data, make([]byte, 80)...)
+}
+
+func mp4Track(handler, codec string, width, height uint32) []byte {
+	tkhd := make([]byte, 76)
+	tkhd = binary.BigEndian.AppendUint32(tkhd, width<<16)
+	tkhd = binary.BigEndian.AppendUint32(tkhd, height<<16)
+
+	hdlr := append(make([]byte, 8), handler...)
+	hdlr = append(hdlr, make([]byte, 13)...)
+
+	stsd := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 16}
+	stsd = append(stsd, codec...)
+
+	return buildMP4Box("trak",
+		buildMP4Box("tkhd", tkhd),
+		buildMP4Box("mdia", buildMP4Box("hdlr", hdlr), buildMP4Box("minf", buildMP4Box("stbl", buildMP4Box("stsd", stsd)))))
+}
+
+func mp4Moov(mvhd []byte) []byte {
+	return buildMP4Box("moov", buildMP4Box("mvhd", mvhd),
+		mp4Track("vide", "avc1", 1080, 1920), mp4Track("soun", "mp4a", 0, 0))
+}
+
+var mp4Ftyp = buildMP4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2avc1mp41"))
+
+func TestReadMP4BoxHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want mp4Box
+		err  bool
+	}{
+		{"32-bit size", buildMP4Box("ftyp", make([]byte, 16)), mp4Box{"ftyp", 24, 8}, false},
+		{"64-bit size", buildLargeMP4Box("mdat", make([]byte, 4)), mp4Box{"mdat", 20, 16}, false},
+		{"size zero runs to the end", []byte("\x00\x00\x00\x00mdat"), mp4Box{"mdat", -1, 8}, false},
+		{"short header", []byte("\x00\x00\x00\x08mo"), mp4Box{}, true},
+		{"short 64-bit header", []byte("\x00\x00\x00\x01mdat\x00\x00"), mp4Box{}, true},
+		{"size below header", []byte("\x00\x00\x00\x04free"), mp4Box{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readMP4BoxHeader(test.data)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want error %v", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseMP4Duration(t *testing.T) {
+	tests := []struct {
+		name string
+		mvhd []byte
+		want float64
+	}{
+		{"version 0", mvhdV0(1000, 12500), 12.5},
+		{"version 1", mvhdV1(90000, 90000*3600*24), 3600 * 24},
+		{"zero timescale", mvhdV0(0, 12500), 0},
+		{"truncated version 0", mvhdV0(1000, 12500)[:18], 0},
+		{"truncated version 1", mvhdV1(1000, 12500)[:30], 0},
+		{"empty", nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseMP4Duration(test.mvhd); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProbeMP4(t *testing.T) {
+	var (
+		moov      = mp4Moov(mvhdV0(600, 9000))
+		smallMdat = buildMP4Box("mdat", make([]byte, 1024))
+		// Bigger than the probe, so that the moov after it needs its own request
+		largeMdat = buildLargeMP4Box("mdat", make([]byte, MP4ProbeSize))
+	)
+
+	tests := []struct {
+		name      string
+		file      []byte
+		fastStart bool
+		err       error
+	}{
+		{"moov before mdat", bytes.Join([][]byte{mp4Ftyp, moov, smallMdat}, nil), true, nil},
+		{"moov after mdat", bytes.Join([][]byte{mp4Ftyp, smallMdat, moov}, nil), false, nil},
+		{"moov after 64-bit mdat", bytes.Join([][]byte{mp4Ftyp, largeMdat, moov}, nil), false, nil},
+		{"moov after free box", bytes.Join([][]byte{mp4Ftyp, buildMP4Box("free"), moov}, nil), true, nil},
+		{"mdat running to the end", bytes.Join([][]byte{mp4Ftyp, []byte("\x00\x00\x00\x00mdat"),
+			make([]byte, 64)}, nil), false, ErrNoMoovBox},
+		{"no moov", bytes.Join([][]byte{mp4Ftyp, smallMdat}, nil), false, ErrNoMoovBox},
+		{"truncated moov", bytes.Join([][]byte{mp4Ftyp, moov[:len(moov)/2]}, nil), false, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(test.file))
+			}))
+			defer server.Close()
+
+			info, err := ProbeMP4(server.URL)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			want := MP4Info{VideoCodec: "avc1", AudioCodec: "mp4a", Duration: 15,
+				Width: 1080, Height: 1920, FastStart: test.fastStart}
+			if *info != want {
+				t.Errorf("got %+v, want %+v", *info, want)
+			}
+			if !info.IsWhatsAppCompatible() {
+				t.Errorf("expected H.264 and AAC to be compatible")
+			}
+		})
+	}
+}
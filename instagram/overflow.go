@@ -0,0 +1,70 @@
+package instagram
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const DefaultWhatsAppMediaLimit = 16 * 1024 * 1024
+
+func whatsAppMediaLimit() int64 {
+	if limit := instaConfig.Overflow.WhatsAppMediaLimit; limit > 0 {
+		return limit
+	}
+	return DefaultWhatsAppMediaLimit
+}
+
+// IsTooLargeForWhatsApp tells if the file should be sent to the Telegram
+// storage channel instead of WhatsApp. This is never the case when no channel
+// has been configured.
+func IsTooLargeForWhatsApp(file *DownloadedFile) bool {
+	return instaConfig.Overflow.TelegramChannelID != 0 && file.Size > whatsAppMediaLimit()
+}
+
+// OverflowToTelegram uploads media that is too large for WhatsApp into the
+// storage channel and replies on WhatsApp with links to the uploaded files.
+func OverflowToTelegram(v *events.Message, chat waTypes.JID, caption string, media []*PreparedMedia) {
+	if len(media) == 0 {
+		return
+	}
+
+	var (
+		target   = TelegramTarget{ChatID: instaConfig.Overflow.TelegramChannelID}
+		links    = make([]string, 0, len(media))
+		tooLarge = make([]string, 0)
+	)
+	for idx, item := range media {
+		if item.File.Size > TelegramUploadLimit {
+			tooLarge = append(tooLarge, fmt.Sprintf("• %s", FormatBytes(item.File.Size)))
+			continue
+		}
+
+		var itemCaption string
+		if idx == 0 && utf8.RuneCountInString(caption) <= TelegramCaptionLimit {
+			itemCaption = caption
+		}
+
+		msg, err := SendFileToTelegram(target, itemCaption, item.File, item.Source.MediaType)
+		if err != nil {
+			replyText(v, chat, fmt.Sprintf("Could not upload the media to Telegram:\n\n%s", err.Error()))
+			continue
+		}
+		links = append(links, fmt.Sprintf("• %s (%s)", TelegramMessageLink(msg),
+			FormatBytes(item.File.Size)))
+	}
+
+	if len(tooLarge) > 0 {
+		replyText(v, chat, fmt.Sprintf("Too big for both WhatsApp and Telegram, which takes up to %s:\n\n%s",
+			FormatBytes(TelegramUploadLimit), strings.Join(tooLarge, "\n")))
+	}
+	if len(links) == 0 {
+		return
+	}
+
+	replyText(v, chat, fmt.Sprintf("Too big for WhatsApp, uploaded to Telegram instead:\n\n%s",
+		strings.Join(links, "\n")))
+}
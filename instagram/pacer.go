@@ -0,0 +1,158 @@
+package instagram
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+const (
+	DefaultAPIRequestsPerMinute = 20
+	DefaultAPIJitter            = 0.5
+	DefaultBreakerFailures      = 3
+	DefaultBreakerCooldown      = 30 * time.Minute
+
+	// Requests that would have to wait longer for their turn are refused
+	MaxAPIWait = 2 * time.Minute
+)
+
+const (
+	APIFailureRateLimited = "rate limited"
+	APIFailureLoginWall   = "login wall"
+	APIFailureCheckpoint  = "checkpoint"
+)
+
+var (
+	pacerLock   sync.Mutex
+	nextAPISlot time.Time
+
+	breakerLock      sync.Mutex
+	breakerFailures  int
+	breakerOpenUntil time.Time
+	breakerReason    string
+)
+
+// WaitForAPI blocks until the next request to the Instagram API is allowed.
+// Requests are spread evenly over a minute with some random jitter, and are
+// refused while the circuit breaker is open or when too many are queued.
+// Downloads from the CDN are not paced.
+func WaitForAPI(ctx context.Context) error {
+	if err := BreakerError(); err != nil {
+		return err
+	}
+
+	rpm := instaConfig.API.RequestsPerMinute
+	if rpm <= 0 {
+		rpm = DefaultAPIRequestsPerMinute
+	}
+	jitter := instaConfig.API.Jitter
+	if jitter <= 0 {
+		jitter = DefaultAPIJitter
+	}
+
+	interval := time.Minute / time.Duration(rpm)
+
+	pacerLock.Lock()
+	slot := time.Now()
+	if nextAPISlot.After(slot) {
+		slot = nextAPISlot
+	}
+	if wait := time.Until(slot); wait > MaxAPIWait {
+		pacerLock.Unlock()
+		return fmt.Errorf("too many requests to Instagram are queued, try again in %s", FormatWait(wait))
+	}
+	nextAPISlot = slot.Add(interval + time.Duration(rand.Float64()*jitter*float64(interval)))
+	pacerLock.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return BreakerError()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ClassifyAPIResponse tells if a response means Instagram is limiting or
+// blocking the session, returning an empty string otherwise.
+func ClassifyAPIResponse(res *http.Response, body []byte) string {
+	path := res.Request.URL.Path
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		return APIFailureRateLimited
+	case strings.HasPrefix(path, "/challenge"), strings.Contains(string(body), `"checkpoint_required"`),
+		strings.Contains(string(body), `"challenge_required"`):
+		return APIFailureCheckpoint
+	case strings.HasPrefix(path, "/accounts/login"), res.StatusCode == http.StatusUnauthorized,
+		strings.Contains(string(body), `"login_required"`):
+		return APIFailureLoginWall
+	default:
+		return ""
+	}
+}
+
+// BreakerError returns an error while the circuit breaker is open.
+func BreakerError() error {
+	breakerLock.Lock()
+	defer breakerLock.Unlock()
+
+	if time.Now().Before(breakerOpenUntil) {
+		return fmt.Errorf("Instagram requests are paused until %s after repeated failures (%s)",
+			breakerOpenUntil.In(quotaNow().Location()).Format("15:04"), breakerReason)
+	}
+	return nil
+}
+
+// RecordAPIResult updates the circuit breaker with the outcome of a request,
+// opening it once there are too many failures in a row.
+func RecordAPIResult(failure string) {
+	breakerLock.Lock()
+
+	if failure == "" {
+		breakerFailures = 0
+		breakerLock.Unlock()
+		return
+	}
+
+	threshold := instaConfig.API.CircuitBreaker.Failures
+	if threshold <= 0 {
+		threshold = DefaultBreakerFailures
+	}
+	cooldown := instaConfig.API.CircuitBreaker.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+
+	breakerFailures += 1
+	if breakerFailures < threshold {
+		breakerLock.Unlock()
+		return
+	}
+
+	// A single failure after the cooldown opens the breaker again
+	breakerFailures = threshold - 1
+	breakerReason = failure
+	breakerOpenUntil = time.Now().Add(cooldown)
+	breakerLock.Unlock()
+
+	alertOwner(fmt.Sprintf("Instagram requests have been stopped for %s because of repeated failures: %s",
+		cooldown.String(), failure))
+}
+
+func alertOwner(text string) {
+	bot := state.State.TelegramBot
+	if bot == nil {
+		return
+	}
+	bot.SendMessage(state.State.Config.Telegram.OwnerID, "[Instagram] "+text, &gotgbot.SendMessageOpts{})
+}
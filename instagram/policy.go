@@ -0,0 +1,170 @@
+package instagram
+
+import (
+	"net/url"
+	"strings"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+
+	FeaturePosts    = "posts"
+	FeatureReels    = "reels"
+	FeatureStories  = "stories"
+	FeatureProfiles = "profiles"
+
+	// Matches every group or contact in a rule
+	PolicyWildcard = "*"
+)
+
+var AllFeatures = []string{FeaturePosts, FeatureReels, FeatureStories, FeatureProfiles}
+
+type PolicyQuota struct {
+	// 0 disables the limit
+	ItemsPerDay int   `yaml:"items_per_day,omitempty"`
+	BytesPerDay int64 `yaml:"bytes_per_day,omitempty"`
+}
+
+// PolicyRule decides whether messages are handled and how. A rule applies if
+// any of its groups, contacts or senders match, and a rule without any of them
+// applies to everything.
+type PolicyRule struct {
+	Name   string `yaml:"name,omitempty"`
+	Action string `yaml:"action"`
+
+	// Group and DM chats are matched on the user part of their JID, and
+	// senders on their phone number
+	Groups   []string `yaml:"groups,omitempty"`
+	Contacts []string `yaml:"contacts,omitempty"`
+	Senders  []string `yaml:"senders,omitempty"`
+
+	// Empty allows every feature
+	Features []string    `yaml:"features,omitempty"`
+	Quota    PolicyQuota `yaml:"quota,omitempty"`
+	// Overrides default_chat_mode, but not the modes set with .igmode
+	Mode string `yaml:"mode,omitempty"`
+}
+
+func (rule *PolicyRule) Allowed() bool {
+	return rule.Action != PolicyDeny
+}
+
+func (rule *PolicyRule) AllowsFeature(feature string) bool {
+	return len(rule.Features) == 0 || slices.Contains(rule.Features, feature)
+}
+
+func (rule *PolicyRule) Matches(chat, sender waTypes.JID, isGroup bool) bool {
+	if len(rule.Groups) == 0 && len(rule.Contacts) == 0 && len(rule.Senders) == 0 {
+		return true
+	}
+
+	if isGroup && matchesPolicyList(rule.Groups, chat.User) {
+		return true
+	}
+	if !isGroup && matchesPolicyList(rule.Contacts, chat.User) {
+		return true
+	}
+	return matchesPolicyList(rule.Senders, sender.User)
+}
+
+func matchesPolicyList(list []string, user string) bool {
+	return slices.Contains(list, PolicyWildcard) || slices.Contains(list, user)
+}
+
+// EffectivePolicy returns the rule for the given message. Rules are tried in
+// the order they are configured and the first one that matches is used. When
+// none match, DMs are allowed and groups only if they are listed in
+// whatsapp_allowed_groups, while messages sent by the bridged account itself
+// are allowed everywhere. The bridged account has no quota unless a matching
+// rule sets one.
+func EffectivePolicy(v *events.Message, chat waTypes.JID) PolicyRule {
+	return policyFor(chat, v.Info.MessageSource.Sender.ToNonAD(),
+		v.Info.IsGroup && !v.Info.IsIncomingBroadcast(), v.Info.IsFromMe)
+}
+
+// OwnerPolicy returns the rule for things the bridged account does in chat
+// without a message, like delivering subscriptions.
+func OwnerPolicy(chat waTypes.JID) PolicyRule {
+	return policyFor(chat, waTypes.EmptyJID, chat.Server == waTypes.GroupServer, true)
+}
+
+func policyFor(chat, sender waTypes.JID, isGroup, isFromMe bool) PolicyRule {
+	var (
+		rule    PolicyRule
+		matched bool
+	)
+
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	for idx := 0; idx < len(instaConfig.Policy.Rules) && !matched; idx++ {
+		if instaConfig.Policy.Rules[idx].Matches(chat, sender, isGroup) {
+			rule, matched = instaConfig.Policy.Rules[idx], true
+		}
+	}
+
+	if !matched && isFromMe {
+		rule, matched = PolicyRule{Name: "owner", Action: PolicyAllow}, true
+	}
+
+	if !matched {
+		rule = PolicyRule{Name: "default", Action: PolicyAllow}
+		if isGroup && !slices.Contains(instaConfig.WhatsAppAllowedGroups, chat.User) {
+			rule.Action = PolicyDeny
+		}
+	}
+
+	if !isFromMe && rule.Quota == (PolicyQuota{}) {
+		rule.Quota = instaConfig.RateLimits.Quota
+	}
+
+	if _, found := instaConfig.ChatModes[chat.User]; found || rule.Mode == "" {
+		rule.Mode = chatMode(chat)
+	}
+
+	return rule
+}
+
+// groupPolicyRule returns the first configured rule that applies to everyone
+// in the group, which whatsapp_allowed_groups then has no say over.
+func groupPolicyRule(group waTypes.JID) (PolicyRule, bool) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	// An empty sender only matches rules that apply to every sender
+	for _, rule := range instaConfig.Policy.Rules {
+		if rule.Matches(group, waTypes.EmptyJID, true) {
+			return rule, true
+		}
+	}
+	return PolicyRule{}, false
+}
+
+// LinkFeature returns the feature that handling the link falls under, or an
+// empty string if it is not an Instagram link.
+func LinkFeature(link string) string {
+	if !IsInstagramLink(link) {
+		return ""
+	}
+
+	parsedURL, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case IsStoriesLink(link):
+		return FeatureStories
+	case strings.HasPrefix(parsedURL.Path, "/reel/"), strings.HasPrefix(parsedURL.Path, "/tv/"):
+		return FeatureReels
+	case strings.HasPrefix(parsedURL.Path, "/p/"):
+		return FeaturePosts
+	default:
+		return FeatureProfiles
+	}
+}
@@ -0,0 +1,78 @@
+package instagram
+
+import (
+	"testing"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+func TestGroupPolicyRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []PolicyRule
+		want  string
+		found bool
+	}{
+		{"no rules", nil, "", false},
+		{"catch all", []PolicyRule{{Name: "all", Action: PolicyAllow}}, "all", true},
+		{"listed group", []PolicyRule{{Name: "group", Groups: []string{"123"}}}, "group", true},
+		{"other group", []PolicyRule{{Name: "other", Groups: []string{"456"}}}, "", false},
+		{"some senders", []PolicyRule{{Name: "senders", Senders: []string{"789"}}}, "", false},
+		{"every sender", []PolicyRule{{Name: "senders", Senders: []string{PolicyWildcard}}}, "senders", true},
+		{"contacts only", []PolicyRule{{Name: "contacts", Contacts: []string{PolicyWildcard}}}, "", false},
+		{"first match", []PolicyRule{
+			{Name: "other", Groups: []string{"456"}},
+			{Name: "first", Groups: []string{PolicyWildcard}},
+			{Name: "second"},
+		}, "first", true},
+	}
+
+	defer func(cfg *Config) { instaConfig = cfg }(instaConfig)
+	group := waTypes.JID{User: "123", Server: waTypes.GroupServer}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instaConfig = &Config{}
+			instaConfig.Policy.Rules = test.rules
+
+			rule, found := groupPolicyRule(group)
+			if found != test.found || rule.Name != test.want {
+				t.Errorf("got %q, %v, want %q, %v", rule.Name, found, test.want, test.found)
+			}
+		})
+	}
+}
+
+func TestOwnerPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []PolicyRule
+		chat   waTypes.JID
+		want   string
+		action string
+	}{
+		{"unlisted group", nil, waTypes.JID{User: "123", Server: waTypes.GroupServer}, "owner", PolicyAllow},
+		{"denied group", []PolicyRule{{Name: "quiet", Action: PolicyDeny, Groups: []string{"123"}}},
+			waTypes.JID{User: "123", Server: waTypes.GroupServer}, "quiet", PolicyDeny},
+		{"contacts only", []PolicyRule{{Name: "dms", Action: PolicyDeny, Contacts: []string{PolicyWildcard}}},
+			waTypes.JID{User: "123", Server: waTypes.GroupServer}, "owner", PolicyAllow},
+		{"denied contact", []PolicyRule{{Name: "dms", Action: PolicyDeny, Contacts: []string{PolicyWildcard}}},
+			waTypes.JID{User: "456", Server: waTypes.DefaultUserServer}, "dms", PolicyDeny},
+		{"some senders", []PolicyRule{{Name: "senders", Action: PolicyDeny, Senders: []string{"789"}}},
+			waTypes.JID{User: "123", Server: waTypes.GroupServer}, "owner", PolicyAllow},
+	}
+
+	defer func(cfg *Config) { instaConfig = cfg }(instaConfig)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instaConfig = &Config{}
+			instaConfig.Policy.Rules = test.rules
+
+			rule := OwnerPolicy(test.chat)
+			if rule.Name != test.want || rule.Action != test.action {
+				t.Errorf("got %q, %q, want %q, %q", rule.Name, rule.Action, test.want, test.action)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package instagram
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	DefaultPostsCount = 3
+	MaxPostsCount     = 12
+	// Smallest side of the covers used for contact sheets
+	PreviewMinSide = 320
+)
+
+// FetchLatestPosts returns up to n of the user's latest posts, newest first,
+// following the feed's cursors for as many pages as needed.
+func FetchLatestPosts(username string, n int) ([]*InstagramPost, error) {
+	var (
+		posts  = make([]*InstagramPost, 0, n)
+		cursor = ""
+	)
+
+	for {
+		items, next, err := FetchUserFeedPage(username, cursor)
+		if err != nil {
+			return posts, err
+		}
+
+		for _, item := range items {
+			if len(posts) == n {
+				return posts, nil
+			}
+			if post, err := ParseFeedItem(item); err == nil {
+				posts = append(posts, post)
+			}
+		}
+
+		if next == "" || len(posts) == n {
+			return posts, nil
+		}
+		cursor = next
+	}
+}
+
+func postLink(post *InstagramPost) string {
+	return fmt.Sprintf("https://www.instagram.com/p/%s/", post.Code)
+}
+
+// quotedUsername returns the user of the profile link or profile card that v
+// replies to, if any.
+func quotedUsername(v *events.Message) string {
+	// The first text is the command itself
+	texts := MessageTexts(v.Message, true)
+	if len(texts) < 2 {
+		return ""
+	}
+
+	for _, link := range ExtractLinks(texts[1:]...) {
+		if LinkFeature(link) != FeatureProfiles {
+			continue
+		}
+		if parsedURL, err := url.Parse(link); err == nil {
+			segment := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")[0]
+			if username, valid := ParseUsername(segment); valid {
+				return username
+			}
+		}
+	}
+
+	for _, text := range texts[1:] {
+		if match := InstagramUsernameRegexp.FindStringSubmatch(text); match != nil {
+			return strings.ToLower(match[1])
+		}
+	}
+	return ""
+}
+
+// parsePostsArgs parses the arguments of .igposts, where quoted is the profile
+// replied to if any. Numbers are only the count once there is a username, as
+// usernames can be made of digits only.
+func parsePostsArgs(args []string, quoted string) (username string, count int, grid bool, valid bool) {
+	count = DefaultPostsCount
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			continue
+		}
+		if username, valid = ParseUsername(arg); !valid {
+			return "", 0, false, false
+		}
+	}
+	if username == "" {
+		username = quoted
+	}
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "@") {
+			continue
+		} else if strings.ToLower(arg) == "grid" {
+			grid = true
+		} else if n, err := strconv.Atoi(arg); err == nil && n > 0 && username != "" {
+			count = n
+		} else if name, ok := ParseUsername(arg); ok && username == "" {
+			username = name
+		} else {
+			return "", 0, false, false
+		}
+	}
+	return username, count, grid, true
+}
+
+func igPostsCommand(v *events.Message, chat waTypes.JID, args []string) {
+	usage := fmt.Sprintf("Usage: *%sigposts @user [n] [grid]*, or reply to a profile with *%sigposts [n] [grid]*",
+		CommandPrefix, CommandPrefix)
+
+	if IsPaused() {
+		replyText(v, chat, "Downloads are paused right now")
+		return
+	}
+
+	username, count, grid, valid := parsePostsArgs(args, quotedUsername(v))
+	if !valid || username == "" {
+		replyText(v, chat, usage)
+		return
+	}
+	if count > MaxPostsCount {
+		count = MaxPostsCount
+	}
+
+	var (
+		policy = EffectivePolicy(v, chat)
+		key    = quotaKey(chat, v.Info.MessageSource.Sender)
+	)
+	if !policy.AllowsFeature(FeaturePosts) {
+		replyText(v, chat, fmt.Sprintf("Downloading *%s* is not allowed here", FeaturePosts))
+		return
+	}
+	reservation := reserveDownload(v, chat, policy)
+	if reservation == nil {
+		return
+	}
+	// Settled by sending the posts, or here when there is nothing to send
+	defer reservation.Settle(0, 0)
+
+	iup, err := FetchUserProfile(fmt.Sprintf(InstagramProfileURL, username))
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not look up *@%s*:\n\n%s", username, err.Error()))
+		return
+	}
+	if user := iup.Graphql.User; user.IsPrivate && !user.FollowedByViewer {
+		replyText(v, chat, fmt.Sprintf("*@%s* is private", username))
+		return
+	}
+
+	posts, err := FetchLatestPosts(username, count)
+	if len(posts) == 0 {
+		if err != nil {
+			replyText(v, chat, fmt.Sprintf("Could not get the posts of *@%s*:\n\n%s", username, err.Error()))
+		} else {
+			replyText(v, chat, fmt.Sprintf("*@%s* has no posts", username))
+		}
+		return
+	}
+
+	if grid {
+		sendPostsPreview(posts, username, v, chat, reservation)
+	} else {
+		sendLatestPosts(posts, v, chat, reservation, key, policy.Quota)
+	}
+}
+
+// sendLatestPosts sends the posts one after another, like links to them, for
+// as long as the quota allows. The first post uses the given reservation.
+func sendLatestPosts(posts []*InstagramPost, v *events.Message, chat waTypes.JID,
+	reservation *QuotaReservation, key string, quota PolicyQuota) {
+
+	for idx, post := range posts {
+		if idx > 0 {
+			var wait time.Duration
+			if reservation, wait = ReserveQuota(key, quota); reservation == nil {
+				replyText(v, chat, fmt.Sprintf("Sent %d of %d posts before running out of today's download quota, try again in %s",
+					idx, len(posts), FormatWait(wait)))
+				return
+			}
+		}
+
+		job := NewDownloadJob()
+		entry := newHistoryEntry(postLink(post), v, chat)
+
+		items := deliverPost(job, post, v, chat, entry)
+		RecordHistory(entry)
+		reservation.Settle(items, job.TotalSize())
+		job.Cleanup()
+	}
+}
+
+// sendPostsPreview sends the covers of the posts as a single contact sheet,
+// along with a numbered list of their links.
+func sendPostsPreview(posts []*InstagramPost, username string, v *events.Message, chat waTypes.JID,
+	reservation *QuotaReservation) {
+
+	job := NewDownloadJob()
+	defer job.Cleanup()
+
+	var (
+		sources = make([]MediaSource, len(posts))
+		links   = make([]string, len(posts))
+		lines   = make([]string, len(posts))
+	)
+	for idx, post := range posts {
+		if len(post.Media) > 0 {
+			sources[idx] = post.Media[0]
+		}
+		links[idx] = postLink(post)
+		lines[idx] = fmt.Sprintf("%d. %s", idx+1, links[idx])
+	}
+
+	caption := fmt.Sprintf("*@%s, latest %d posts*\n\n%s\n\n_Reply with a number to get that post_",
+		username, len(posts), strings.Join(lines, "\n"))
+
+	size, err := sendContactSheet(downloadPreviews(job, sources), caption, ContactSheetPosts, links, v, chat)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not send the preview:\n\n%s", err.Error()))
+		return
+	}
+	reservation.Settle(1, job.TotalSize()+size)
+}
+
+func init() {
+	registerCommand(&WaCommand{
+		Name:    "igposts",
+		Usage:   "igposts @user [n] [grid]",
+		Help:    fmt.Sprintf("Send the user's latest n posts (at most %d), or a grid of their covers, also works as a reply to a profile", MaxPostsCount),
+		Handler: igPostsCommand,
+	})
+}
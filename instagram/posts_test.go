@@ -0,0 +1,37 @@
+package instagram
+
+import "testing"
+
+func TestParsePostsArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		quoted   string
+		username string
+		count    int
+		grid     bool
+		valid    bool
+	}{
+		{"username", []string{"@someone"}, "", "someone", DefaultPostsCount, false, true},
+		{"username without at", []string{"someone", "5"}, "", "someone", 5, false, true},
+		{"count before username", []string{"5", "@someone"}, "", "someone", 5, false, true},
+		{"digits username", []string{"12345"}, "", "12345", DefaultPostsCount, false, true},
+		{"digits username with count", []string{"12345", "3", "grid"}, "", "12345", 3, true, true},
+		{"replied to profile", []string{"3"}, "someone", "someone", 3, false, true},
+		{"username over reply", []string{"@other", "grid"}, "someone", "other", DefaultPostsCount, true, true},
+		{"nothing", nil, "", "", DefaultPostsCount, false, true},
+		{"two usernames", []string{"someone", "other"}, "", "", 0, false, false},
+		{"invalid username", []string{"@some/one"}, "", "", 0, false, false},
+		{"zero count", []string{"@someone", "0"}, "", "", 0, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			username, count, grid, valid := parsePostsArgs(test.args, test.quoted)
+			if username != test.username || count != test.count || grid != test.grid || valid != test.valid {
+				t.Errorf("got %q, %d, %v, %v, want %q, %d, %v, %v", username, count, grid, valid,
+					test.username, test.count, test.grid, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package instagram
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionProfile = "profile"
+
+	DefaultProfileInterval = 6 * time.Hour
+)
+
+// ProfileSnapshot is what a profile looked like when it was checked. The
+// profile picture is kept under ProfilePicRoot() as <sha256>.jpg so that it
+// can be sent again when it changes.
+type ProfileSnapshot struct {
+	ID               uint   `gorm:"primaryKey"`
+	Username         string `gorm:"index"`
+	UserID           string
+	FullName         string
+	Biography        string
+	IsPrivate        bool
+	Followers        int64
+	Following        int64
+	ProfilePicSHA256 string
+	ProfilePicPath   string
+	CreatedAt        time.Time `gorm:"index"`
+}
+
+func (ProfileSnapshot) TableName() string {
+	return "instagram_profile_snapshots"
+}
+
+// ProfilePicRoot is where profile pictures are kept. It is outside of the
+// archive, as older pictures are still needed after the retention.
+func ProfilePicRoot() string {
+	return filepath.Join("downloads", "instagram_profiles")
+}
+
+func profilePicDir(username string) string {
+	return filepath.Join(ProfilePicRoot(), username)
+}
+
+// TakeProfileSnapshot fetches the profile of username along with its picture
+// and saves it as a new snapshot.
+func TakeProfileSnapshot(username string) (*ProfileSnapshot, error) {
+	iup, err := FetchUserProfile(fmt.Sprintf(InstagramProfileURL, username))
+	if err != nil {
+		return nil, err
+	}
+
+	user := iup.Graphql.User
+	snapshot := &ProfileSnapshot{
+		Username:  username,
+		UserID:    user.ID,
+		FullName:  user.FullName,
+		Biography: user.Biography,
+		IsPrivate: user.IsPrivate,
+		Followers: iup.Followers(),
+		Following: iup.Following(),
+	}
+
+	if picURL := iup.ProfilePicURLHD(); picURL != "" {
+		snapshot.ProfilePicSHA256, snapshot.ProfilePicPath, err = saveProfilePic(username, picURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, db.Create(snapshot).Error
+}
+
+// saveProfilePic downloads a profile picture into its user's directory, where
+// it is named after its hash so that an unchanged picture is only stored once.
+func saveProfilePic(username, picURL string) (string, string, error) {
+	job := NewDownloadJob()
+	defer job.Cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", picURL, nil)
+	file, err := job.Download(req)
+	if err != nil {
+		return "", "", fmt.Errorf("could not download profile picture : %s", err)
+	}
+
+	var (
+		sum      = hex.EncodeToString(file.SHA256)
+		dir      = profilePicDir(username)
+		filePath = filepath.Join(dir, sum+mediaFileExtension(file.Mimetype))
+	)
+	if _, err := os.Stat(filePath); err == nil {
+		return sum, filePath, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("could not create profile directory : %s", err)
+	}
+	if err := copyFile(file.Path, filePath); err != nil {
+		return "", "", fmt.Errorf("could not save profile picture : %s", err)
+	}
+	return sum, filePath, nil
+}
+
+func findProfileSnapshot(id uint) (*ProfileSnapshot, error) {
+	if id == 0 {
+		return nil, nil
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot ProfileSnapshot
+	err = db.First(&snapshot, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return &snapshot, err
+}
+
+// FollowerMilestone returns the largest milestone that the follower count
+// went past between from and to, in either direction, or 0 if it did not go
+// past any. Milestones are 1, 2 and 5 times every power of ten from 100.
+func FollowerMilestone(from, to int64) int64 {
+	low, high := from, to
+	if low > high {
+		low, high = high, low
+	}
+
+	var crossed int64
+	for power := int64(100); power <= high; power *= 10 {
+		for _, factor := range []int64{1, 2, 5} {
+			if milestone := power * factor; low < milestone && milestone <= high {
+				crossed = milestone
+			}
+		}
+	}
+	return crossed
+}
+
+// DiffProfiles describes what changed from old to new, one line per change.
+func DiffProfiles(old, new *ProfileSnapshot) []string {
+	changes := make([]string, 0)
+
+	if old.FullName != new.FullName {
+		changes = append(changes, fmt.Sprintf("*Name:* %s → %s", orDash(old.FullName), orDash(new.FullName)))
+	}
+	if old.Biography != new.Biography {
+		changes = append(changes, fmt.Sprintf("*Bio was:*\n%s\n\n*Bio is now:*\n%s",
+			orDash(old.Biography), orDash(new.Biography)))
+	}
+	if old.IsPrivate != new.IsPrivate {
+		if new.IsPrivate {
+			changes = append(changes, "The account is now *private*")
+		} else {
+			changes = append(changes, "The account is now *public*")
+		}
+	}
+	if milestone := FollowerMilestone(old.Followers, new.Followers); milestone > 0 {
+		if new.Followers > old.Followers {
+			changes = append(changes, fmt.Sprintf("Reached *%s* followers (%d → %d)",
+				FormatCount(milestone), old.Followers, new.Followers))
+		} else {
+			changes = append(changes, fmt.Sprintf("Dropped below *%s* followers (%d → %d)",
+				FormatCount(milestone), old.Followers, new.Followers))
+		}
+	}
+	if old.ProfilePicSHA256 != new.ProfilePicSHA256 && new.ProfilePicSHA256 != "" {
+		changes = append(changes, "Changed the profile picture")
+	}
+
+	return changes
+}
+
+func orDash(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "-"
+	}
+	return value
+}
+
+// FormatCount shortens a count like 12500 to 12.5K.
+func FormatCount(count int64) string {
+	switch {
+	case count >= 1_000_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(count)/1_000_000), ".0") + "M"
+	case count >= 1_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(count)/1_000), ".0") + "K"
+	default:
+		return fmt.Sprint(count)
+	}
+}
+
+// pollProfile takes a snapshot of the profile and reports what changed since
+// the snapshot that the subscription last saw. The first poll only takes the
+// snapshot.
+func pollProfile(sub *Subscription) error {
+	chat, err := sub.ChatJID()
+	if err != nil {
+		return err
+	}
+
+	if err := checkSubscriptionPolicy(chat, FeatureProfiles); err != nil {
+		return err
+	}
+
+	snapshot, err := TakeProfileSnapshot(sub.Username)
+	if err != nil {
+		return err
+	}
+	sub.UserID = snapshot.UserID
+
+	previous, err := findProfileSnapshot(sub.LastSnapshotID)
+	if err != nil {
+		return err
+	}
+	sub.LastSnapshotID, sub.Primed = snapshot.ID, true
+	if previous == nil {
+		return nil
+	}
+
+	changes := DiffProfiles(previous, snapshot)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	replyText(nil, chat, fmt.Sprintf("*@%s updated their profile*\n\n%s",
+		sub.Username, strings.Join(changes, "\n\n")))
+
+	if previous.ProfilePicSHA256 != snapshot.ProfilePicSHA256 && snapshot.ProfilePicPath != "" {
+		sendProfilePic(chat, previous.ProfilePicPath, "Old profile picture")
+		sendProfilePic(chat, snapshot.ProfilePicPath, "New profile picture")
+	}
+	return nil
+}
+
+func sendProfilePic(chat waTypes.JID, filePath, caption string) {
+	if filePath == "" {
+		return
+	}
+
+	file, err := LocalFile(filePath)
+	if err != nil {
+		replyText(nil, chat, fmt.Sprintf("Could not read the %s:\n\n%s",
+			strings.ToLower(caption), err.Error()))
+		return
+	}
+
+	if _, err := SendImageFile(nil, chat, file, caption); err != nil {
+		replyText(nil, chat, fmt.Sprintf("Could not send the %s:\n\n%s",
+			strings.ToLower(caption), err.Error()))
+	}
+}
+
+func init() {
+	registerSubscriptionKind(SubscriptionProfile, &SubscriptionKind{
+		Interval: func() time.Duration {
+			return subscriptionInterval(&instaConfig.Subscriptions.ProfileInterval, DefaultProfileInterval)
+		},
+		Poll: pollProfile,
+	})
+
+	registerCommand(&WaCommand{
+		Name:      "igwatchprofile",
+		Usage:     "igwatchprofile [@user]",
+		Help:      "Report changes to the user's name, bio, picture, privacy and followers in this chat, or list the watched users",
+		OwnerOnly: true,
+		Handler:   subscriptionCommand(SubscriptionProfile, "igwatchprofile @user"),
+	})
+	registerCommand(&WaCommand{
+		Name:      "igunwatchprofile",
+		Usage:     "igunwatchprofile @user",
+		Help:      "Stop reporting changes to the user's profile in this chat",
+		OwnerOnly: true,
+		Handler:   unsubscriptionCommand(SubscriptionProfile, "igunwatchprofile @user"),
+	})
+}
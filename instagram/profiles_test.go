@@ -0,0 +1,33 @@
+package instagram
+
+import "testing"
+
+func TestFollowerMilestone(t *testing.T) {
+	tests := []struct {
+		name string
+		from int64
+		to   int64
+		want int64
+	}{
+		{"no change", 9_500, 9_500, 0},
+		{"below the first milestone", 10, 99, 0},
+		{"reaching the first milestone", 99, 100, 100},
+		{"already at a milestone", 100, 150, 0},
+		{"reaching 2x", 1_999, 2_000, 2_000},
+		{"reaching 5x", 4_900, 5_100, 5_000},
+		{"crossing 10K", 9_950, 10_020, 10_000},
+		{"largest of several", 150, 25_000, 20_000},
+		{"into the millions", 999_999, 1_000_000, 1_000_000},
+		{"dropping below", 10_020, 9_950, 10_000},
+		{"dropping from a milestone", 5_000, 4_999, 5_000},
+		{"dropping onto a milestone", 5_001, 5_000, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FollowerMilestone(test.from, test.to); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package instagram
+
+import (
+	"sync"
+	"time"
+
+	"watgbridge/state"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaUsage is what was downloaded under a key on a day. It is kept in the
+// database so that restarting the bridge does not reset the quotas.
+type QuotaUsage struct {
+	ID    uint   `gorm:"primaryKey"`
+	Key   string `gorm:"column:quota_key;uniqueIndex:idx_instagram_quota_usage"`
+	Day   string `gorm:"uniqueIndex:idx_instagram_quota_usage"`
+	Items int
+	Bytes int64
+}
+
+func (QuotaUsage) TableName() string {
+	return "instagram_quota_usages"
+}
+
+// Quotas are reset at midnight in the bridge's time zone
+func quotaNow() time.Time {
+	now := time.Now()
+	if state.State.LocalLocation != nil {
+		now = now.In(state.State.LocalLocation)
+	}
+	return now
+}
+
+func quotaDay() string {
+	return quotaNow().Format("2006-01-02")
+}
+
+// quotaKey identifies whose usage is counted, which is a sender within a chat.
+func quotaKey(chat, sender waTypes.JID) string {
+	return chat.User + "/" + sender.ToNonAD().User
+}
+
+// GetQuotaUsage returns what has been used today under the key.
+func GetQuotaUsage(key string) QuotaUsage {
+	usage := QuotaUsage{Key: key, Day: quotaDay()}
+
+	db, err := getDatabase()
+	if err != nil {
+		return usage
+	}
+
+	var usages []QuotaUsage
+	db.Where("quota_key = ? AND day = ?", usage.Key, usage.Day).Limit(1).Find(&usages)
+	if len(usages) == 0 {
+		return usage
+	}
+	return usages[0]
+}
+
+// QuotaRetryAfter returns how long it is until the key can download again, or
+// 0 if the quota has not been used up.
+func QuotaRetryAfter(key string, quota PolicyQuota) time.Duration {
+	usage := GetQuotaUsage(key)
+	if (quota.ItemsPerDay > 0 && usage.Items >= quota.ItemsPerDay) ||
+		(quota.BytesPerDay > 0 && usage.Bytes >= quota.BytesPerDay) {
+		now := quotaNow()
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		return tomorrow.Sub(now)
+	}
+	return 0
+}
+
+var quotaLock sync.Mutex
+
+// QuotaReservation is an item of the quota taken before a download, so that
+// links handled at the same time can not go over the quota together. Bytes
+// are only known afterwards and are not reserved.
+type QuotaReservation struct {
+	key     string
+	settled bool
+}
+
+// ReserveQuota takes an item of today's quota under the key. When the quota is
+// used up nothing is taken, and how long until it allows more is returned.
+func ReserveQuota(key string, quota PolicyQuota) (*QuotaReservation, time.Duration) {
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+
+	if wait := QuotaRetryAfter(key, quota); wait > 0 {
+		return nil, wait
+	}
+	RecordQuotaUsage(key, 1, 0)
+	return &QuotaReservation{key: key}, 0
+}
+
+// Settle replaces the reserved item with what the download actually used.
+func (reservation *QuotaReservation) Settle(items int, bytes int64) {
+	if reservation == nil || reservation.settled {
+		return
+	}
+	reservation.settled = true
+	RecordQuotaUsage(reservation.key, items-1, bytes)
+}
+
+// RecordQuotaUsage adds to today's usage under the key.
+func RecordQuotaUsage(key string, items int, bytes int64) {
+	db, err := getDatabase()
+	if err != nil {
+		return
+	}
+
+	db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "quota_key"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"items": gorm.Expr("instagram_quota_usages.items + ?", items),
+			"bytes": gorm.Expr("instagram_quota_usages.bytes + ?", bytes),
+		}),
+	}).Create(&QuotaUsage{Key: key, Day: quotaDay(), Items: items, Bytes: bytes})
+}
@@ -0,0 +1,143 @@
+package instagram
+
+import (
+	"sync"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+type TokenBucketConfig struct {
+	// Links allowed per interval, 0 disables the limit
+	Rate     int           `yaml:"rate"`
+	Interval time.Duration `yaml:"interval"`
+	// Links that can be sent at once, defaults to the rate
+	Burst int `yaml:"burst"`
+}
+
+func (cfg TokenBucketConfig) enabled() bool {
+	return cfg.Rate > 0 && cfg.Interval > 0
+}
+
+func (cfg TokenBucketConfig) burst() float64 {
+	if cfg.Burst > 0 {
+		return float64(cfg.Burst)
+	}
+	return float64(cfg.Rate)
+}
+
+// refillTime is how long an empty bucket takes to be full again.
+func (cfg TokenBucketConfig) refillTime() time.Duration {
+	return time.Duration(cfg.burst() * float64(cfg.Interval) / float64(cfg.Rate))
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last call and returns how long it
+// takes until a whole token is available.
+func (bucket *tokenBucket) refill(cfg TokenBucketConfig, now time.Time) time.Duration {
+	perToken := cfg.Interval / time.Duration(cfg.Rate)
+
+	bucket.tokens += float64(now.Sub(bucket.last)) / float64(perToken)
+	if bucket.tokens > cfg.burst() {
+		bucket.tokens = cfg.burst()
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) * float64(perToken))
+}
+
+// How often buckets that are full again are dropped
+const RateLimitPruneInterval = 10 * time.Minute
+
+var (
+	rateLimitLock      sync.Mutex
+	senderBuckets      = map[string]*tokenBucket{}
+	chatBuckets        = map[string]*tokenBucket{}
+	lastRateLimitPrune time.Time
+)
+
+func getTokenBucket(buckets map[string]*tokenBucket, key string, cfg TokenBucketConfig, now time.Time) *tokenBucket {
+	bucket, found := buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: cfg.burst(), last: now}
+		buckets[key] = bucket
+	}
+	return bucket
+}
+
+// pruneTokenBuckets drops the buckets that have not been used for long enough
+// to be full again, as a new bucket would be the same.
+func pruneTokenBuckets(buckets map[string]*tokenBucket, cfg TokenBucketConfig, now time.Time) {
+	for key, bucket := range buckets {
+		if !cfg.enabled() || now.Sub(bucket.last) >= cfg.refillTime() {
+			delete(buckets, key)
+		}
+	}
+}
+
+// TakeRateLimitToken takes a token from both the sender's and the chat's
+// bucket. If either of them is empty, nothing is taken and the time until a
+// link would be accepted is returned.
+func TakeRateLimitToken(chat, sender waTypes.JID) (bool, time.Duration) {
+	return takeRateLimitToken(chat.User, sender.ToNonAD().User)
+}
+
+// takeRateLimitToken is TakeRateLimitToken for chats and senders that are not
+// on WhatsApp, like Telegram ones.
+func takeRateLimitToken(chatKey, senderKey string) (bool, time.Duration) {
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+
+	var (
+		now     = time.Now()
+		limits  = []TokenBucketConfig{instaConfig.RateLimits.Sender, instaConfig.RateLimits.Chat}
+		maps    = []map[string]*tokenBucket{senderBuckets, chatBuckets}
+		keys    = []string{senderKey, chatKey}
+		buckets = make([]*tokenBucket, 0, len(limits))
+		wait    time.Duration
+	)
+
+	if now.Sub(lastRateLimitPrune) >= RateLimitPruneInterval {
+		for idx, cfg := range limits {
+			pruneTokenBuckets(maps[idx], cfg, now)
+		}
+		lastRateLimitPrune = now
+	}
+
+	for idx, cfg := range limits {
+		if !cfg.enabled() {
+			continue
+		}
+		bucket := getTokenBucket(maps[idx], keys[idx], cfg, now)
+		if bucketWait := bucket.refill(cfg, now); bucketWait > wait {
+			wait = bucketWait
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+	for _, bucket := range buckets {
+		bucket.tokens -= 1
+	}
+	return true, 0
+}
+
+// FormatWait rounds a duration for showing it in a reply.
+func FormatWait(wait time.Duration) string {
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if wait >= time.Minute {
+		return wait.Round(time.Minute).String()
+	}
+	return wait.Round(time.Second).String()
+}
@@ -0,0 +1,37 @@
+package instagram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneTokenBuckets(t *testing.T) {
+	var (
+		now = time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+		cfg = TokenBucketConfig{Rate: 2, Interval: time.Minute, Burst: 4}
+	)
+
+	tests := []struct {
+		name   string
+		cfg    TokenBucketConfig
+		idle   time.Duration
+		pruned bool
+	}{
+		{"just used", cfg, 0, false},
+		{"refilling", cfg, 90 * time.Second, false},
+		{"full again", cfg, 2 * time.Minute, true},
+		{"long idle", cfg, time.Hour, true},
+		{"burst defaults to rate", TokenBucketConfig{Rate: 2, Interval: time.Minute}, 90 * time.Second, true},
+		{"limit disabled", TokenBucketConfig{}, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buckets := map[string]*tokenBucket{"key": {last: now.Add(-test.idle)}}
+			pruneTokenBuckets(buckets, test.cfg, now)
+			if _, found := buckets["key"]; found == test.pruned {
+				t.Errorf("got pruned %v, want %v", !found, test.pruned)
+			}
+		})
+	}
+}
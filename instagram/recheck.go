@@ -0,0 +1,218 @@
+package instagram
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modules-watgbridge/instagram/history"
+	"watgbridge/utils"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultRecheckInterval = 6 * time.Hour
+	DefaultRecheckWindow   = 72 * time.Hour
+	// Most shared media looked at again per scheduler tick, so that the
+	// checks do not use up the api pacing
+	MaxRechecksPerTick = 5
+	// Longer captions are compared but not shown line by line
+	MaxCaptionDiffLines = 40
+)
+
+// SharedMedia is a post that was sent to a chat, kept to notice when it is
+// later deleted or has its caption edited.
+type SharedMedia struct {
+	ID            uint   `gorm:"primaryKey"`
+	MediaID       string `gorm:"uniqueIndex:idx_instagram_shared_media"`
+	Chat          string `gorm:"uniqueIndex:idx_instagram_shared_media"`
+	Shortcode     string
+	Owner         string
+	Link          string
+	CaptionText   string
+	CaptionEdited bool
+	ArchiveDir    string
+	Sender        string
+	MessageID     string
+	Removed       bool
+	SharedAt      time.Time `gorm:"index"`
+	CheckedAt     time.Time `gorm:"index"`
+}
+
+func (SharedMedia) TableName() string {
+	return "instagram_shared_media"
+}
+
+// TrackSharedMedia remembers a post that was delivered for entry, replacing
+// what was known about an earlier share of it in the same chat. Stories are
+// not tracked as they disappear by themselves.
+func TrackSharedMedia(post *InstagramPost, entry *history.Entry) error {
+	if !instaConfig.Recheck.Enabled || post.IsStory || entry.MediaID == "" || entry.Items == 0 {
+		return nil
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "media_id"}, {Name: "chat"}},
+		DoUpdates: clause.AssignmentColumns([]string{"shortcode", "owner", "link", "caption_text",
+			"caption_edited", "archive_dir", "sender", "message_id", "removed", "shared_at", "checked_at"}),
+	}).Create(&SharedMedia{
+		MediaID:       entry.MediaID,
+		Chat:          entry.Chat,
+		Shortcode:     post.Code,
+		Owner:         post.Owner,
+		Link:          entry.Link,
+		CaptionText:   post.CaptionText,
+		CaptionEdited: post.CaptionEdited,
+		ArchiveDir:    filepath.FromSlash(post.DownloadPath),
+		Sender:        entry.Sender,
+		MessageID:     entry.MessageID,
+		SharedAt:      now,
+		CheckedAt:     now,
+	}).Error
+}
+
+// runRechecks fetches the media shared within the window that was not checked
+// for a while, and reports the ones that were deleted or had their caption
+// edited since.
+func runRechecks() {
+	if !instaConfig.Recheck.Enabled || IsPaused() || BreakerError() != nil {
+		return
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return
+	}
+
+	var (
+		interval = subscriptionInterval(&instaConfig.Recheck.Interval, DefaultRecheckInterval)
+		window   = subscriptionInterval(&instaConfig.Recheck.Window, DefaultRecheckWindow)
+		due      []SharedMedia
+	)
+	err = db.Where("removed = ? AND shared_at >= ? AND checked_at < ?",
+		false, time.Now().Add(-window), time.Now().Add(-interval)).
+		Order("checked_at").Limit(MaxRechecksPerTick).Find(&due).Error
+	if err != nil {
+		return
+	}
+
+	for idx := range due {
+		if IsPaused() || BreakerError() != nil {
+			return
+		}
+
+		shared := &due[idx]
+		recheckSharedMedia(shared)
+		shared.CheckedAt = time.Now()
+		db.Save(shared)
+	}
+}
+
+func recheckSharedMedia(shared *SharedMedia) {
+	chat, err := waTypes.ParseJID(shared.Chat)
+	if err != nil {
+		return
+	}
+
+	post, err := FetchPost(fmt.Sprintf("https://www.instagram.com/p/%s/", shared.Shortcode))
+	if errors.Is(err, ErrNotFound) {
+		shared.Removed = true
+		replySharedMedia(chat, shared, fmt.Sprintf("*@%s deleted this post*\n\n%s",
+			shared.Owner, archivedCopyNote(shared.ArchiveDir)))
+		return
+	} else if err != nil {
+		return
+	}
+
+	flipped := post.CaptionEdited && !shared.CaptionEdited
+	if post.CaptionText == shared.CaptionText && !flipped {
+		return
+	}
+
+	replySharedMedia(chat, shared, fmt.Sprintf("*@%s edited the caption of this post*\n\n%s",
+		shared.Owner, CaptionDiff(shared.CaptionText, post.CaptionText)))
+	shared.CaptionText, shared.CaptionEdited = post.CaptionText, post.CaptionEdited
+}
+
+func archivedCopyNote(dir string) string {
+	if dir == "" {
+		return "No copy of it was archived"
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) == 0 {
+		return "No copy of it was archived"
+	}
+	return fmt.Sprintf("The archived copy is in `%s`", dir)
+}
+
+// replySharedMedia quotes the message the media was asked for with, or just
+// sends the text if it was sent for a subscription.
+func replySharedMedia(chat waTypes.JID, shared *SharedMedia, text string) {
+	if shared.MessageID == "" {
+		replyText(nil, chat, text+"\n\n"+shared.Link)
+		return
+	}
+
+	utils.WaSendText(chat, text, shared.MessageID, shared.Sender,
+		&waProto.Message{Conversation: proto.String(shared.Link)}, true)
+}
+
+// CaptionDiff shows which lines of the caption were removed and added.
+func CaptionDiff(old, new string) string {
+	var (
+		oldLines = strings.Split(old, "\n")
+		newLines = strings.Split(new, "\n")
+	)
+	if len(oldLines) > MaxCaptionDiffLines || len(newLines) > MaxCaptionDiffLines {
+		return fmt.Sprintf("*Caption was:*\n%s\n\n*Caption is now:*\n%s", orDash(old), orDash(new))
+	}
+
+	// Longest common subsequence of lines, filled in from the end
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]string, 0)
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			i, j = i+1, j+1
+		case j == len(newLines) || (i < len(oldLines) && common[i+1][j] >= common[i][j+1]):
+			diff = append(diff, "- "+oldLines[i])
+			i++
+		default:
+			diff = append(diff, "+ "+newLines[j])
+			j++
+		}
+	}
+
+	if len(diff) == 0 {
+		return "The text did not visibly change"
+	}
+	return "```" + strings.Join(diff, "\n") + "```"
+}
@@ -0,0 +1,37 @@
+package instagram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCaptionDiff(t *testing.T) {
+	var (
+		long       = strings.Repeat("line\n", MaxCaptionDiffLines) + "last"
+		longEdited = strings.Repeat("line\n", MaxCaptionDiffLines) + "edited"
+	)
+
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{"unchanged", "a\nb", "a\nb", "The text did not visibly change"},
+		{"changed line", "a\nb\nc", "a\nB\nc", "```- b\n+ B```"},
+		{"added line", "a", "a\nb", "```+ b```"},
+		{"removed line", "a\nb\nc", "a\nc", "```- b```"},
+		{"moved line", "a\nb", "b\na", "```- a\n+ a```"},
+		{"everything changed", "a\nb", "c", "```- a\n- b\n+ c```"},
+		{"too many lines", long, longEdited, "*Caption was:*\n" + long + "\n\n*Caption is now:*\n" + longEdited},
+		{"too many lines to nothing", long, "", "*Caption was:*\n" + long + "\n\n*Caption is now:*\n-"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CaptionDiff(test.old, test.new); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
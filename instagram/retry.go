@@ -0,0 +1,170 @@
+package instagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultRetryAttempts  = 3
+	DefaultRetryBaseDelay = time.Second
+	DefaultRetryMaxDelay  = 30 * time.Second
+
+	DefaultAPITimeout      = 30 * time.Second
+	DefaultDownloadTimeout = 5 * time.Minute
+	DefaultUploadTimeout   = 5 * time.Minute
+	DefaultSendTimeout     = time.Minute
+)
+
+// RetryableError marks an error as temporary, so that the call which returned
+// it is worth making again.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError returns an error for an unexpected HTTP status, which is
+// retryable for server errors and timeouts.
+func StatusError(res *http.Response) error {
+	err := fmt.Errorf("Received status '%s'", res.Status)
+	if isRetryableStatus(res.StatusCode) {
+		return &RetryableError{Err: err}
+	}
+	return err
+}
+
+func isRetryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout
+}
+
+// IsRetryable tells if err is temporary: timeouts of a single attempt,
+// connections that were reset, refused or closed halfway, and errors marked as
+// RetryableError, like 408 and 5xx responses. Other failures, such as a host
+// that does not resolve or a bad certificate, would only fail again.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var retryable *RetryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsRetryableUploadError classifies the errors returned by whatsmeow's Upload,
+// which only wraps the underlying error for some of them.
+func IsRetryableUploadError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var code int
+	if _, scanErr := fmt.Sscanf(err.Error(), "upload failed with status code %d", &code); scanErr == nil {
+		return isRetryableStatus(code) || code == http.StatusTooManyRequests
+	}
+
+	return IsRetryable(err) ||
+		strings.HasPrefix(err.Error(), "failed to refresh media connections") ||
+		strings.HasPrefix(err.Error(), "failed to execute request")
+}
+
+func retryAttempts() int {
+	if attempts := instaConfig.Retries.Attempts; attempts > 0 {
+		return attempts
+	}
+	return DefaultRetryAttempts
+}
+
+// retryDelay returns the backoff before the given retry, which doubles every
+// time and is picked at random up to that bound.
+func retryDelay(retry int) time.Duration {
+	base, maxDelay := instaConfig.Retries.BaseDelay, instaConfig.Retries.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+
+	delay := base << uint(retry)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Retry calls fn until it succeeds, returns an error that classify does not
+// consider temporary, or runs out of attempts. Every attempt gets its own
+// timeout, and ctx bounds all of them together.
+func Retry(ctx context.Context, timeout time.Duration, classify func(error) bool,
+	fn func(ctx context.Context) error) error {
+
+	var err error
+	for attempt := 0; attempt < retryAttempts(); attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(retryDelay(attempt - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = fn(attemptCtx)
+		cancel()
+
+		if err == nil || !classify(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func timeoutOrDefault(timeout, fallback time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return fallback
+}
+
+func apiTimeout() time.Duration {
+	return timeoutOrDefault(instaConfig.Timeouts.API, DefaultAPITimeout)
+}
+
+func downloadTimeout() time.Duration {
+	return timeoutOrDefault(instaConfig.Timeouts.Download, DefaultDownloadTimeout)
+}
+
+func uploadTimeout() time.Duration {
+	return timeoutOrDefault(instaConfig.Timeouts.Upload, DefaultUploadTimeout)
+}
+
+func sendTimeout() time.Duration {
+	return timeoutOrDefault(instaConfig.Timeouts.Send, DefaultSendTimeout)
+}
@@ -0,0 +1,57 @@
+package instagram
+
+import (
+	"encoding/json"
+)
+
+const InstagramCurrentUserURL = "https://www.instagram.com/api/v1/accounts/current_user/?edit=true"
+
+// Cookies without which requests are not made as the logged in user
+var RequiredSessionCookies = []string{"sessionid", "ds_user_id", "csrftoken"}
+
+type SessionStatus struct {
+	LoggedIn bool
+	Username string
+	// Why the session is not logged in, as returned by Instagram
+	Message string
+}
+
+// CheckSession asks Instagram which account the configured cookies belong to.
+func CheckSession() (*SessionStatus, error) {
+	body, err := fetchJSON(InstagramCurrentUserURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		// Logged out sessions are redirected to the HTML login page
+		return &SessionStatus{Message: "not a JSON response, probably the login page"}, nil
+	}
+
+	return &SessionStatus{
+		LoggedIn: res.Status == "ok" && res.User.Username != "",
+		Username: res.User.Username,
+		Message:  res.Message,
+	}, nil
+}
+
+// MissingSessionCookies returns the required cookies that are not configured.
+func MissingSessionCookies() []string {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	missing := make([]string, 0)
+	for _, name := range RequiredSessionCookies {
+		if instaConfig.Cookies[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
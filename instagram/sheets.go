@@ -0,0 +1,182 @@
+package instagram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	ContactSheetCarousel = "carousel"
+	ContactSheetPosts    = "posts"
+
+	// Replies to older contact sheets are ignored
+	ContactSheetExpiry = 7 * 24 * time.Hour
+)
+
+// ContactSheet is a sent grid of numbered previews. Replying to it with a
+// number sends the item behind that number.
+type ContactSheet struct {
+	ID        uint   `gorm:"primaryKey"`
+	MessageID string `gorm:"uniqueIndex"`
+	Chat      string
+	Kind      string
+	// The carousel post, or the posts of a grid one per line
+	Links     string
+	Count     int
+	CreatedAt time.Time
+}
+
+func (ContactSheet) TableName() string {
+	return "instagram_contact_sheets"
+}
+
+func RecordContactSheet(messageID string, chat waTypes.JID, kind string, links []string, count int) error {
+	db, err := getDatabase()
+	if err != nil {
+		return err
+	}
+
+	return db.Create(&ContactSheet{
+		MessageID: messageID,
+		Chat:      chat.String(),
+		Kind:      kind,
+		Links:     strings.Join(links, "\n"),
+		Count:     count,
+	}).Error
+}
+
+func FindContactSheet(chat waTypes.JID, messageID string) *ContactSheet {
+	db, err := getDatabase()
+	if err != nil {
+		return nil
+	}
+
+	var sheets []ContactSheet
+	db.Where("message_id = ? AND chat = ? AND created_at >= ?", messageID, chat.String(),
+		time.Now().Add(-ContactSheetExpiry)).Limit(1).Find(&sheets)
+	if len(sheets) == 0 {
+		return nil
+	}
+	return &sheets[0]
+}
+
+// sendCarouselSheet sends a carousel as a single contact sheet of its slides,
+// and returns how many messages were delivered. The previews are neither
+// archived nor mirrored, only the slides asked for in full are archived.
+func sendCarouselSheet(job *DownloadJob, post *InstagramPost, v *events.Message, chat waTypes.JID) int {
+	previews := downloadPreviews(job, post.Media)
+
+	failed := make([]string, 0)
+	for idx, preview := range previews {
+		if preview == nil {
+			failed = append(failed, strconv.Itoa(idx+1))
+		}
+	}
+
+	caption := post.Caption
+	if len(failed) == 1 {
+		caption += fmt.Sprintf("\n\n_No preview of slide %s_", failed[0])
+	} else if len(failed) > 1 {
+		caption += fmt.Sprintf("\n\n_No previews of slides %s_", strings.Join(failed, ", "))
+	}
+	caption += "\n\n_Reply with a number to get that slide in full_"
+
+	_, err := sendContactSheet(previews, caption, ContactSheetCarousel, []string{postLink(post)}, v, chat)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not send the contact sheet:\n\n%s", err.Error()))
+		return 0
+	}
+	return 1
+}
+
+// handleSheetReply sends the item picked by replying to a contact sheet with
+// its number, and reports whether the message was such a reply.
+func handleSheetReply(text string, v *events.Message, chat waTypes.JID, policy PolicyRule) bool {
+	number, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || policy.Mode == ChatModeOff {
+		return false
+	}
+
+	messageID := QuotedMessageID(v.Message)
+	if messageID == "" {
+		return false
+	}
+	sheet := FindContactSheet(chat, messageID)
+	if sheet == nil {
+		return false
+	}
+
+	if number < 1 || number > sheet.Count {
+		replyText(v, chat, fmt.Sprintf("Reply with a number from 1 to %d", sheet.Count))
+		return true
+	}
+	if IsPaused() {
+		replyText(v, chat, "Downloads are paused right now")
+		return true
+	}
+
+	links := strings.Split(sheet.Links, "\n")
+	switch sheet.Kind {
+	case ContactSheetPosts:
+		if number <= len(links) {
+			processLinks([]string{links[number-1]}, v, chat, policy, true)
+		}
+	case ContactSheetCarousel:
+		sendCarouselSlide(links[0], number-1, v, chat, policy)
+	}
+	return true
+}
+
+// sendCarouselSlide sends a single slide of a carousel in full.
+func sendCarouselSlide(link string, idx int, v *events.Message, chat waTypes.JID, policy PolicyRule) {
+	if !policy.AllowsFeature(FeaturePosts) {
+		replyText(v, chat, fmt.Sprintf("Downloading *%s* is not allowed here", FeaturePosts))
+		return
+	}
+	reservation := reserveDownload(v, chat, policy)
+	if reservation == nil {
+		return
+	}
+
+	job := NewDownloadJob()
+	defer job.Cleanup()
+
+	items := 0
+	defer func() { reservation.Settle(items, job.TotalSize()) }()
+
+	post, err := FetchPost(link)
+	if err != nil {
+		replyText(v, chat, err.Error())
+		return
+	} else if idx >= len(post.Media) {
+		replyText(v, chat, fmt.Sprintf("The post no longer has a slide %d", idx+1))
+		return
+	}
+
+	job.KeepFiles = archivesPost(post)
+
+	caption := fmt.Sprintf("Slide %d of %d", idx+1, len(post.Media))
+	media, err := PrepareMedia(context.Background(), job, post.Media[idx], caption, v)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not download the media:\n\n%s", err.Error()))
+		return
+	}
+
+	files := make([]*DownloadedFile, len(post.Media))
+	files[idx] = media.File
+	ArchivePost(post, files, archiveRequestFor(v, chat))
+
+	if media.TooLarge {
+		OverflowToTelegram(v, chat, caption, []*PreparedMedia{media})
+	} else if _, err := sendWhatsAppMessage(chat, media.Message); err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not send the slide:\n\n%s", err.Error()))
+		return
+	}
+	items = 1
+}
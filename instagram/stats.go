@@ -0,0 +1,216 @@
+package instagram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	SubscriptionStats = "stats"
+
+	DefaultStatsInterval = 12 * time.Hour
+	DefaultStatsPeriod   = 30 * 24 * time.Hour
+)
+
+// ParseStatsPeriod reads a period like 30d or 8w, or anything that
+// time.ParseDuration accepts.
+func ParseStatsPeriod(value string) (time.Duration, error) {
+	if value == "" {
+		return DefaultStatsPeriod, nil
+	}
+
+	unit := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[value[len(value)-1]]
+	if unit == 0 {
+		period, err := time.ParseDuration(value)
+		if err != nil || period <= 0 {
+			return 0, fmt.Errorf("could not parse period '%s'", value)
+		}
+		return period, nil
+	}
+
+	count, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("could not parse period '%s'", value)
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// ProfileSnapshots returns the snapshots of username taken since the given
+// time, oldest first.
+func ProfileSnapshots(username string, since time.Time) ([]ProfileSnapshot, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []ProfileSnapshot
+	err = db.Where("username = ? AND created_at >= ?", username, since).
+		Order("created_at").Find(&snapshots).Error
+	return snapshots, err
+}
+
+func latestProfileSnapshot(username string) (*ProfileSnapshot, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []ProfileSnapshot
+	if err := db.Where("username = ?", username).Order("created_at DESC").Limit(1).
+		Find(&snapshots).Error; err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return &snapshots[0], nil
+}
+
+// pollStats takes a snapshot of the profile, unless one was taken recently
+// for another chat or for .igwatchprofile.
+func pollStats(sub *Subscription) error {
+	interval := subscriptionInterval(&instaConfig.Subscriptions.StatsInterval, DefaultStatsInterval)
+
+	latest, err := latestProfileSnapshot(sub.Username)
+	if err != nil {
+		return err
+	}
+	if latest == nil || time.Since(latest.CreatedAt) >= interval/2 {
+		if latest, err = TakeProfileSnapshot(sub.Username); err != nil {
+			return err
+		}
+	}
+
+	sub.UserID, sub.Primed = latest.UserID, true
+	return nil
+}
+
+// StatsSummary describes how the counts changed from the first snapshot to
+// the last one.
+func StatsSummary(username string, period time.Duration, snapshots []ProfileSnapshot) string {
+	var (
+		first = snapshots[0]
+		last  = snapshots[len(snapshots)-1]
+		days  = last.CreatedAt.Sub(first.CreatedAt).Hours() / 24
+	)
+
+	lines := []string{
+		fmt.Sprintf("*@%s, last %s*", username, FormatPeriod(period)),
+		"",
+		"Followers: " + formatGrowth(first.Followers, last.Followers),
+		"Following: " + formatGrowth(first.Following, last.Following),
+	}
+	if days >= 1 {
+		lines = append(lines, fmt.Sprintf("About %+.0f followers a day", float64(last.Followers-first.Followers)/days))
+	}
+	lines = append(lines, fmt.Sprintf("_%d snapshots since %s_", len(snapshots),
+		first.CreatedAt.In(quotaNow().Location()).Format("02 Jan 2006 15:04")))
+
+	return strings.Join(lines, "\n")
+}
+
+func formatGrowth(from, to int64) string {
+	growth := fmt.Sprintf("%s → %s (%+d", FormatCount(from), FormatCount(to), to-from)
+	if from > 0 {
+		growth += fmt.Sprintf(", %+.1f%%", float64(to-from)*100/float64(from))
+	}
+	return growth + ")"
+}
+
+// FormatPeriod writes whole days as 30d and anything else as a duration.
+func FormatPeriod(period time.Duration) string {
+	if period%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", period/(24*time.Hour))
+	}
+	return period.String()
+}
+
+func igStatsCommand(v *events.Message, chat waTypes.JID, args []string) {
+	usage := fmt.Sprintf("Usage: *%sigstats @user [30d]*", CommandPrefix)
+	if len(args) == 0 {
+		replyText(v, chat, usage)
+		return
+	}
+
+	username, valid := ParseUsername(args[0])
+	if !valid {
+		replyText(v, chat, usage)
+		return
+	}
+
+	var periodArg string
+	if len(args) > 1 {
+		periodArg = args[1]
+	}
+	period, err := ParseStatsPeriod(periodArg)
+	if err != nil {
+		replyText(v, chat, usage)
+		return
+	}
+
+	snapshots, err := ProfileSnapshots(username, time.Now().Add(-period))
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not get the snapshots:\n\n%s", err.Error()))
+		return
+	} else if len(snapshots) < 2 {
+		replyText(v, chat, fmt.Sprintf("Not enough snapshots of *@%s* in the last %s, start taking them with *%sigtrack @%s*",
+			username, FormatPeriod(period), CommandPrefix, username))
+		return
+	}
+
+	followers := ChartSeries{Title: fmt.Sprintf("@%s followers", username)}
+	following := ChartSeries{Title: fmt.Sprintf("@%s following", username)}
+	for _, snapshot := range snapshots {
+		followers.Points = append(followers.Points, ChartPoint{snapshot.CreatedAt, snapshot.Followers})
+		following.Points = append(following.Points, ChartPoint{snapshot.CreatedAt, snapshot.Following})
+	}
+
+	chart, err := RenderLineChart(followers, following)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not draw the chart:\n\n%s", err.Error()))
+		return
+	}
+
+	file, err := TempFile(chart)
+	if err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not save the chart:\n\n%s", err.Error()))
+		return
+	}
+	defer file.Remove()
+
+	if _, err := SendImageFile(v, chat, file, StatsSummary(username, period, snapshots)); err != nil {
+		replyText(v, chat, fmt.Sprintf("Could not send the chart:\n\n%s", err.Error()))
+	}
+}
+
+func init() {
+	registerSubscriptionKind(SubscriptionStats, &SubscriptionKind{
+		Interval: func() time.Duration {
+			return subscriptionInterval(&instaConfig.Subscriptions.StatsInterval, DefaultStatsInterval)
+		},
+		Poll: pollStats,
+	})
+
+	registerCommand(&WaCommand{
+		Name:      "igtrack",
+		Usage:     "igtrack [@user]",
+		Help:      "Take regular snapshots of the user's follower counts for .igstats, or list the tracked users",
+		OwnerOnly: true,
+		Handler:   subscriptionCommand(SubscriptionStats, "igtrack @user"),
+	})
+	registerCommand(&WaCommand{
+		Name:      "iguntrack",
+		Usage:     "iguntrack @user",
+		Help:      "Stop taking snapshots of the user's follower counts for this chat",
+		OwnerOnly: true,
+		Handler:   unsubscriptionCommand(SubscriptionStats, "iguntrack @user"),
+	})
+	registerCommand(&WaCommand{
+		Name:    "igstats",
+		Usage:   "igstats @user [30d]",
+		Help:    "Chart the user's followers and following over a period like 30d or 8w",
+		Handler: igStatsCommand,
+	})
+}
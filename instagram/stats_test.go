@@ -0,0 +1,41 @@
+package instagram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStatsPeriod(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"", DefaultStatsPeriod, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"8w", 8 * 7 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"0d", 0, true},
+		{"-2w", 0, true},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"-1h", 0, true},
+		{"0s", 0, true},
+		{"month", 0, true},
+		{"30", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseStatsPeriod(test.value)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want error %v", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
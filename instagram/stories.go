@@ -0,0 +1,153 @@
+package instagram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	SubscriptionStories = "stories"
+
+	DefaultStoriesInterval = time.Hour
+
+	InstagramReelsMediaURL = "https://www.instagram.com/api/v1/feed/reels_media/?reel_ids=%s"
+	InstagramProfileURL    = "https://www.instagram.com/%s/"
+)
+
+// FetchUserID looks up the numeric ID of a user, which the story endpoints
+// need instead of the username.
+func FetchUserID(username string) (string, error) {
+	iup, err := FetchUserProfile(fmt.Sprintf(InstagramProfileURL, username))
+	if err != nil {
+		return "", err
+	}
+	return iup.Graphql.User.ID, nil
+}
+
+// FetchStories returns the user's current stories along with the raw JSON of
+// every item. A user without stories has no items.
+func FetchStories(userID string) (*InstagramStory, []json.RawMessage, error) {
+	body, err := fetchJSON(fmt.Sprintf(InstagramReelsMediaURL, userID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var res struct {
+		Reels map[string]json.RawMessage `json:"reels"`
+	}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not parse body into reels media:\n\n%s", err.Error())
+	}
+
+	reel, found := res.Reels[userID]
+	if !found {
+		return &InstagramStory{}, nil, nil
+	}
+
+	var (
+		is       InstagramStory
+		rawItems struct {
+			Items []json.RawMessage `json:"items"`
+		}
+	)
+	if err := json.Unmarshal(reel, &is); err != nil {
+		return nil, nil, fmt.Errorf("Could not parse body into InstagramStory:\n\n%s", err.Error())
+	}
+	json.Unmarshal(reel, &rawItems)
+
+	return &is, rawItems.Items, nil
+}
+
+// StoryPost wraps a single story item as a post, so that it can be sent
+// and archived like one.
+func (is InstagramStory) StoryPost(idx int, raw json.RawMessage) *InstagramPost {
+	item := is.Items[idx]
+	return &InstagramPost{
+		ID:           strconv.FormatInt(item.PK, 10),
+		MediaType:    item.MediaType,
+		Caption:      is.Caption(),
+		Owner:        is.User.Username,
+		TakenAt:      takenAt(item.TakenAt),
+		DownloadPath: is.DownloadPath(),
+		IsStory:      true,
+		Media:        []MediaSource{is.MediaSource(item.PK)},
+		Raw:          raw,
+	}
+}
+
+// pollStories delivers the stories of the account that have not been seen
+// yet. Unlike posts, the stories that are up when subscribing are delivered
+// too, as they are gone within a day.
+func pollStories(sub *Subscription) error {
+	chat, err := sub.ChatJID()
+	if err != nil {
+		return err
+	}
+
+	if err := checkSubscriptionPolicy(chat, FeatureStories); err != nil {
+		return err
+	}
+
+	if sub.UserID == "" {
+		if sub.UserID, err = FetchUserID(sub.Username); err != nil {
+			return err
+		}
+	}
+
+	story, rawItems, err := FetchStories(sub.UserID)
+	if err != nil {
+		return err
+	}
+
+	delivered := 0
+	for idx := 0; idx < len(story.Items) && delivered < MaxItemsPerPoll; idx++ {
+		pk := strconv.FormatInt(story.Items[idx].PK, 10)
+		if IsSeen(sub, pk) {
+			continue
+		}
+
+		var raw json.RawMessage
+		if idx < len(rawItems) {
+			raw = rawItems[idx]
+		}
+
+		post := story.StoryPost(idx, raw)
+		delivered += 1
+		if !deliverSubscribedPost(post, chat,
+			fmt.Sprintf("https://www.instagram.com/stories/%s/%s/", sub.Username, pk)) {
+			continue
+		}
+
+		MarkSeen(sub, pk)
+	}
+
+	sub.Primed = true
+	return nil
+}
+
+func init() {
+	registerSubscriptionKind(SubscriptionStories, &SubscriptionKind{
+		Interval: func() time.Duration {
+			return subscriptionInterval(&instaConfig.Subscriptions.StoriesInterval, DefaultStoriesInterval)
+		},
+		Poll: pollStories,
+	})
+
+	registerCommand(&WaCommand{
+		Name:      "igwatchstories",
+		Usage:     "igwatchstories [@user]",
+		Help:      "Send new stories of the user to this chat, or list the watched users",
+		OwnerOnly: true,
+		Handler:   subscriptionCommand(SubscriptionStories, "igwatchstories @user"),
+	})
+	registerCommand(&WaCommand{
+		Name:      "igunwatchstories",
+		Usage:     "igunwatchstories @user",
+		Help:      "Stop sending new stories of the user to this chat",
+		OwnerOnly: true,
+		Handler:   unsubscriptionCommand(SubscriptionStories, "igunwatchstories @user"),
+	})
+}
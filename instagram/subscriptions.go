@@ -0,0 +1,374 @@
+package instagram
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	waTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const SubscriptionPosts = "posts"
+
+const (
+	DefaultPostsInterval = 30 * time.Minute
+	// How often subscriptions are looked at to see if any is due
+	SchedulerTick = time.Minute
+	// Most new items delivered for a subscription at once, the rest is
+	// delivered on the following polls
+	MaxItemsPerPoll = 5
+)
+
+// Subscription is an Instagram account that a chat receives updates about.
+// What is received depends on the kind.
+type Subscription struct {
+	ID       uint   `gorm:"primaryKey"`
+	Kind     string `gorm:"uniqueIndex:idx_instagram_subscription"`
+	Username string `gorm:"uniqueIndex:idx_instagram_subscription"`
+	Chat     string `gorm:"uniqueIndex:idx_instagram_subscription"`
+	// Resolved when first needed, for the endpoints that take the ID
+	UserID string
+	// Items that existed when subscribing are only marked as seen
+	Primed bool
+	// The profile snapshot that changes are reported against
+	LastSnapshotID uint
+	LastCheckedAt  time.Time
+	LastError      string
+	CreatedAt      time.Time
+}
+
+func (Subscription) TableName() string {
+	return "instagram_subscriptions"
+}
+
+func (sub *Subscription) ChatJID() (waTypes.JID, error) {
+	return waTypes.ParseJID(sub.Chat)
+}
+
+// SeenItem is an item that was already handled for a subscription.
+type SeenItem struct {
+	ID             uint   `gorm:"primaryKey"`
+	SubscriptionID uint   `gorm:"uniqueIndex:idx_instagram_seen_item"`
+	ItemID         string `gorm:"uniqueIndex:idx_instagram_seen_item"`
+	CreatedAt      time.Time
+}
+
+func (SeenItem) TableName() string {
+	return "instagram_seen_items"
+}
+
+// SubscriptionKind is how a kind of subscription is polled.
+type SubscriptionKind struct {
+	Interval func() time.Duration
+	Poll     func(sub *Subscription) error
+}
+
+var subscriptionKinds = map[string]*SubscriptionKind{}
+
+func registerSubscriptionKind(name string, kind *SubscriptionKind) {
+	subscriptionKinds[name] = kind
+}
+
+// ParseUsername accepts a username with or without the @.
+func ParseUsername(value string) (string, bool) {
+	username := strings.ToLower(strings.TrimPrefix(value, "@"))
+	match := InstagramUsernameRegexp.FindString("@" + username)
+	return username, len(username) <= 30 && match == "@"+username
+}
+
+// Subscribe adds a subscription, and reports false if it already existed.
+func Subscribe(kind, username string, chat waTypes.JID) (bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return false, err
+	}
+
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Subscription{
+		Kind:     kind,
+		Username: username,
+		Chat:     chat.String(),
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+// Unsubscribe removes a subscription, and reports false if it did not exist.
+func Unsubscribe(kind, username string, chat waTypes.JID) (bool, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return false, err
+	}
+
+	var sub Subscription
+	err = db.Where("kind = ? AND username = ? AND chat = ?", kind, username, chat.String()).
+		First(&sub).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := db.Where("subscription_id = ?", sub.ID).Delete(&SeenItem{}).Error; err != nil {
+		return false, err
+	}
+	return true, db.Delete(&sub).Error
+}
+
+func ChatSubscriptions(kind string, chat waTypes.JID) ([]Subscription, error) {
+	db, err := getDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []Subscription
+	err = db.Where("kind = ? AND chat = ?", kind, chat.String()).Order("username").Find(&subs).Error
+	return subs, err
+}
+
+func IsSeen(sub *Subscription, itemID string) bool {
+	db, err := getDatabase()
+	if err != nil {
+		return false
+	}
+
+	var count int64
+	db.Model(&SeenItem{}).Where("subscription_id = ? AND item_id = ?", sub.ID, itemID).Count(&count)
+	return count > 0
+}
+
+func MarkSeen(sub *Subscription, itemID string) error {
+	db, err := getDatabase()
+	if err != nil {
+		return err
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&SeenItem{SubscriptionID: sub.ID, ItemID: itemID}).Error
+}
+
+var schedulerOnce sync.Once
+
+// startScheduler polls the subscriptions that are due every SchedulerTick,
+// followed by the rechecks of shared media. Polls run one after another and
+// their requests go through the API pacer.
+func startScheduler() {
+	schedulerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(SchedulerTick)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				runDueSubscriptions()
+				runRechecks()
+			}
+		}()
+	})
+}
+
+func runDueSubscriptions() {
+	if IsPaused() || BreakerError() != nil {
+		return
+	}
+
+	db, err := getDatabase()
+	if err != nil {
+		return
+	}
+
+	var subs []Subscription
+	if err := db.Order("last_checked_at").Find(&subs).Error; err != nil {
+		return
+	}
+
+	for idx := range subs {
+		sub := &subs[idx]
+
+		kind, found := subscriptionKinds[sub.Kind]
+		if !found || time.Since(sub.LastCheckedAt) < kind.Interval() {
+			continue
+		}
+		if IsPaused() || BreakerError() != nil {
+			return
+		}
+
+		sub.LastError = ""
+		if err := kind.Poll(sub); err != nil {
+			sub.LastError = err.Error()
+		}
+		sub.LastCheckedAt = time.Now()
+		db.Save(sub)
+	}
+}
+
+// subscriptionInterval reads the configured interval, which is a field of
+// instaConfig, falling back to the default when it is not set.
+func subscriptionInterval(interval *time.Duration, fallback time.Duration) time.Duration {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	if *interval > 0 {
+		return *interval
+	}
+	return fallback
+}
+
+// pollPosts delivers the posts of the account that have not been seen yet,
+// oldest first.
+func pollPosts(sub *Subscription) error {
+	chat, err := sub.ChatJID()
+	if err != nil {
+		return err
+	}
+
+	if err := checkSubscriptionPolicy(chat, FeaturePosts); err != nil {
+		return err
+	}
+
+	items, err := FetchUserFeed(sub.Username)
+	if err != nil {
+		return err
+	}
+
+	delivered := 0
+	for idx := len(items) - 1; idx >= 0 && delivered < MaxItemsPerPoll; idx-- {
+		post, err := ParseFeedItem(items[idx])
+		if err != nil {
+			continue
+		}
+
+		mediaID := CanonicalMediaID(post.ID)
+		if IsSeen(sub, mediaID) {
+			continue
+		}
+
+		if sub.Primed {
+			delivered += 1
+			if !deliverSubscribedPost(post, chat, fmt.Sprintf("https://www.instagram.com/p/%s/", post.Code)) {
+				continue
+			}
+		}
+		MarkSeen(sub, mediaID)
+	}
+
+	sub.Primed = true
+	return nil
+}
+
+// checkSubscriptionPolicy tells why subscribed items of feature can not be
+// delivered to chat, if they can not. Subscriptions are set up by the bridged
+// account, so its policy applies.
+func checkSubscriptionPolicy(chat waTypes.JID, feature string) error {
+	policy := OwnerPolicy(chat)
+	if !policy.Allowed() {
+		return fmt.Errorf("the policy rule '%s' denies this chat", policy.Name)
+	} else if !policy.AllowsFeature(feature) {
+		return fmt.Errorf("the policy rule '%s' does not allow %s", policy.Name, feature)
+	} else if policy.Mode == ChatModeOff {
+		return fmt.Errorf("links are turned off in this chat")
+	}
+	return nil
+}
+
+// deliverSubscribedPost sends a new post of a subscription, and reports
+// whether it was delivered. Posts that were not are tried again on the next
+// poll.
+func deliverSubscribedPost(post *InstagramPost, chat waTypes.JID, link string) bool {
+	if IsPaused() {
+		return false
+	}
+
+	entry := newHistoryEntry(link, nil, chat)
+	defer RecordHistory(entry)
+
+	job := NewDownloadJob()
+	defer job.Cleanup()
+
+	return deliverPost(job, post, nil, chat, entry) > 0
+}
+
+func subscriptionCommand(kind, usage string) func(v *events.Message, chat waTypes.JID, args []string) {
+	return func(v *events.Message, chat waTypes.JID, args []string) {
+		if len(args) == 0 {
+			subs, err := ChatSubscriptions(kind, chat)
+			if err != nil {
+				replyText(v, chat, fmt.Sprintf("Could not get the subscriptions:\n\n%s", err.Error()))
+				return
+			} else if len(subs) == 0 {
+				replyText(v, chat, fmt.Sprintf("Usage: *%s%s*", CommandPrefix, usage))
+				return
+			}
+
+			lines := make([]string, 0, len(subs))
+			for _, sub := range subs {
+				line := "• @" + sub.Username
+				if sub.LastError != "" {
+					line += fmt.Sprintf(" (last check failed: %s)", sub.LastError)
+				}
+				lines = append(lines, line)
+			}
+			replyText(v, chat, fmt.Sprintf("*Subscribed to %s of*\n\n%s", kind, strings.Join(lines, "\n")))
+			return
+		}
+
+		username, valid := ParseUsername(args[0])
+		if !valid {
+			replyText(v, chat, fmt.Sprintf("Usage: *%s%s*", CommandPrefix, usage))
+			return
+		}
+
+		created, err := Subscribe(kind, username, chat)
+		if err != nil {
+			replyText(v, chat, fmt.Sprintf("Could not subscribe:\n\n%s", err.Error()))
+		} else if !created {
+			replyText(v, chat, fmt.Sprintf("Already subscribed to %s of *@%s*", kind, username))
+		} else {
+			replyText(v, chat, fmt.Sprintf("Subscribed to %s of *@%s*", kind, username))
+		}
+	}
+}
+
+func unsubscriptionCommand(kind, usage string) func(v *events.Message, chat waTypes.JID, args []string) {
+	return func(v *events.Message, chat waTypes.JID, args []string) {
+		if len(args) == 0 {
+			replyText(v, chat, fmt.Sprintf("Usage: *%s%s*", CommandPrefix, usage))
+			return
+		}
+		username, _ := ParseUsername(args[0])
+
+		removed, err := Unsubscribe(kind, username, chat)
+		if err != nil {
+			replyText(v, chat, fmt.Sprintf("Could not unsubscribe:\n\n%s", err.Error()))
+		} else if !removed {
+			replyText(v, chat, fmt.Sprintf("Not subscribed to %s of *@%s*", kind, username))
+		} else {
+			replyText(v, chat, fmt.Sprintf("Unsubscribed from %s of *@%s*", kind, username))
+		}
+	}
+}
+
+func init() {
+	registerSubscriptionKind(SubscriptionPosts, &SubscriptionKind{
+		Interval: func() time.Duration {
+			return subscriptionInterval(&instaConfig.Subscriptions.PostsInterval, DefaultPostsInterval)
+		},
+		Poll: pollPosts,
+	})
+
+	registerCommand(&WaCommand{
+		Name:      "igfollow",
+		Usage:     "igfollow [@user]",
+		Help:      "Send new posts of the user to this chat, or list the followed users",
+		OwnerOnly: true,
+		Handler:   subscriptionCommand(SubscriptionPosts, "igfollow @user"),
+	})
+	registerCommand(&WaCommand{
+		Name:      "igunfollow",
+		Usage:     "igunfollow @user",
+		Help:      "Stop sending new posts of the user to this chat",
+		OwnerOnly: true,
+		Handler:   unsubscriptionCommand(SubscriptionPosts, "igunfollow @user"),
+	})
+}
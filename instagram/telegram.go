@@ -0,0 +1,236 @@
+package instagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"watgbridge/database"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	waTypes "go.mau.fi/whatsmeow/types"
+)
+
+const (
+	TelegramCaptionLimit    = 1024
+	TelegramMediaGroupLimit = 10
+	TelegramUploadTimeout   = 5 * time.Minute
+	// The Bot API does not take uploads larger than this
+	TelegramUploadLimit = 50 * 1024 * 1024
+)
+
+var ErrTelegramUploadLimit = errors.New("file is larger than Telegram bots can upload")
+
+// TelegramTarget is where media is sent to on the Telegram side.
+type TelegramTarget struct {
+	ChatID           int64
+	ThreadID         int64
+	ReplyToMessageID int64
+}
+
+// telegramTargetForChat returns the Telegram chat and topic that the given
+// WhatsApp chat is bridged to.
+func telegramTargetForChat(chat waTypes.JID) (TelegramTarget, error) {
+	tgChatId := state.State.Config.Telegram.TargetChatID
+
+	threadId, found, err := database.ChatThreadGetTgFromWa(chat.ToNonAD().String(), tgChatId)
+	if err != nil {
+		return TelegramTarget{}, err
+	} else if !found {
+		return TelegramTarget{}, fmt.Errorf("no Telegram topic found for '%s'", chat.String())
+	}
+
+	return TelegramTarget{ChatID: tgChatId, ThreadID: threadId}, nil
+}
+
+// MirrorToTelegram sends the given media to the Telegram topic of the bridged
+// chat. The files downloaded for WhatsApp are reused, and media without one is
+// fetched again. Files too large for the Bot API are left out.
+func MirrorToTelegram(job *DownloadJob, chat waTypes.JID, caption string, media []*PreparedMedia) error {
+	if !instaConfig.Telegram.MirrorMedia || len(media) == 0 {
+		return nil
+	}
+
+	target, err := telegramTargetForChat(chat)
+	if err != nil {
+		return err
+	}
+
+	files := make([]*DownloadedFile, 0, len(media))
+	sources := make([]MediaSource, 0, len(media))
+	for _, item := range media {
+		file := item.File
+		if file == nil {
+			file, _, err = DownloadMedia(context.Background(), job, item.Source)
+			if err != nil {
+				continue
+			}
+		}
+		if file.Size > TelegramUploadLimit {
+			continue
+		}
+		files = append(files, file)
+		sources = append(sources, item.Source)
+	}
+
+	return SendFilesToTelegram(target, caption, files, sources)
+}
+
+// SendFilesToTelegram uploads the files as a single message or as media
+// groups, with the caption attached to the first one when it fits.
+func SendFilesToTelegram(target TelegramTarget, caption string, files []*DownloadedFile,
+	sources []MediaSource) error {
+
+	var (
+		bot          = state.State.TelegramBot
+		requestOpts  = &gotgbot.RequestOpts{Timeout: TelegramUploadTimeout}
+		mediaCaption = caption
+	)
+
+	if len(files) == 0 {
+		return fmt.Errorf("nothing to send")
+	}
+	if utf8.RuneCountInString(caption) > TelegramCaptionLimit {
+		mediaCaption = ""
+	}
+
+	if len(files) == 1 {
+		_, err := SendFileToTelegram(target, mediaCaption, files[0], sources[0].MediaType)
+		if err != nil {
+			return err
+		}
+	} else {
+		for start := 0; start < len(files); start += TelegramMediaGroupLimit {
+			end := start + TelegramMediaGroupLimit
+			if end > len(files) {
+				end = len(files)
+			}
+
+			err := sendTelegramMediaGroup(target, mediaCaption,
+				files[start:end], sources[start:end], requestOpts)
+			if err != nil {
+				return err
+			}
+			mediaCaption = ""
+		}
+	}
+
+	if caption != "" && utf8.RuneCountInString(caption) > TelegramCaptionLimit {
+		_, err := bot.SendMessage(target.ChatID, caption, &gotgbot.SendMessageOpts{
+			MessageThreadId:  target.ThreadID,
+			ReplyToMessageId: target.ReplyToMessageID,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// SendFileToTelegram uploads a single photo or video and returns the message
+// that was sent.
+func SendFileToTelegram(target TelegramTarget, caption string, df *DownloadedFile,
+	mediaType int) (*gotgbot.Message, error) {
+
+	var (
+		bot         = state.State.TelegramBot
+		requestOpts = &gotgbot.RequestOpts{Timeout: TelegramUploadTimeout}
+	)
+
+	if df.Size > TelegramUploadLimit {
+		return nil, ErrTelegramUploadLimit
+	}
+
+	file, err := os.Open(df.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	namedFile := gotgbot.NamedFile{File: file, FileName: filepath.Base(df.Path)}
+	if mediaType == MediaTypeVideo {
+		return bot.SendVideo(target.ChatID, namedFile, &gotgbot.SendVideoOpts{
+			MessageThreadId:   target.ThreadID,
+			ReplyToMessageId:  target.ReplyToMessageID,
+			Caption:           caption,
+			SupportsStreaming: true,
+			RequestOpts:       requestOpts,
+		})
+	}
+	return bot.SendPhoto(target.ChatID, namedFile, &gotgbot.SendPhotoOpts{
+		MessageThreadId:  target.ThreadID,
+		ReplyToMessageId: target.ReplyToMessageID,
+		Caption:          caption,
+		RequestOpts:      requestOpts,
+	})
+}
+
+// TelegramMessageLink returns a t.me link to a message in a channel or
+// supergroup.
+func TelegramMessageLink(msg *gotgbot.Message) string {
+	if msg.Chat.Username != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", msg.Chat.Username, msg.MessageId)
+	}
+	// Private links use the chat ID without the -100 prefix
+	internalId := strings.TrimPrefix(strconv.FormatInt(msg.Chat.Id, 10), "-100")
+	return fmt.Sprintf("https://t.me/c/%s/%d", internalId, msg.MessageId)
+}
+
+func sendTelegramMediaGroup(target TelegramTarget, caption string, files []*DownloadedFile,
+	sources []MediaSource, requestOpts *gotgbot.RequestOpts) error {
+
+	// Telegram needs at least two items in a group
+	if len(files) == 1 {
+		return SendFilesToTelegram(target, caption, files, sources)
+	}
+
+	var (
+		inputMedia  = make([]gotgbot.InputMedia, 0, len(files))
+		openedFiles = make([]*os.File, 0, len(files))
+	)
+	defer func() {
+		for _, file := range openedFiles {
+			file.Close()
+		}
+	}()
+
+	for idx, df := range files {
+		file, err := os.Open(df.Path)
+		if err != nil {
+			return err
+		}
+		openedFiles = append(openedFiles, file)
+
+		var itemCaption string
+		if idx == 0 {
+			itemCaption = caption
+		}
+
+		namedFile := gotgbot.NamedFile{File: file, FileName: filepath.Base(df.Path)}
+		if sources[idx].MediaType == MediaTypeVideo {
+			inputMedia = append(inputMedia, gotgbot.InputMediaVideo{
+				Media:             namedFile,
+				Caption:           itemCaption,
+				SupportsStreaming: true,
+			})
+		} else {
+			inputMedia = append(inputMedia, gotgbot.InputMediaPhoto{
+				Media:   namedFile,
+				Caption: itemCaption,
+			})
+		}
+	}
+
+	_, err := state.State.TelegramBot.SendMediaGroup(target.ChatID, inputMedia, &gotgbot.SendMediaGroupOpts{
+		MessageThreadId:  target.ThreadID,
+		ReplyToMessageId: target.ReplyToMessageID,
+		RequestOpts:      requestOpts,
+	})
+	return err
+}
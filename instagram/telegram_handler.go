@@ -0,0 +1,237 @@
+package instagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"modules-watgbridge/instagram/history"
+	"watgbridge/state"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+	"golang.org/x/exp/slices"
+)
+
+// Handlers in a separate group do not interfere with the bridge's own ones
+const TelegramHandlerGroup = 10
+
+var (
+	telegramHandlerLock       sync.Mutex
+	telegramHandlerRegistered bool
+)
+
+// registerTelegramHandler adds the module's handler to the bridge's Telegram
+// dispatcher. The dispatcher is only created after the modules are loaded, so
+// this is called once the WhatsApp client has connected.
+func registerTelegramHandler() {
+	telegramHandlerLock.Lock()
+	defer telegramHandlerLock.Unlock()
+
+	dispatcher := state.State.TelegramDispatcher
+	if telegramHandlerRegistered || dispatcher == nil {
+		return
+	}
+
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(
+		telegramMessageFilter, InstagramModuleTelegramHandler,
+	), TelegramHandlerGroup)
+	telegramHandlerRegistered = true
+}
+
+func telegramMessageFilter(msg *gotgbot.Message) bool {
+	if IsPaused() || (msg.Text == "" && msg.Caption == "") {
+		return false
+	}
+	return slices.Contains(instaConfig.Telegram.AllowedChats, msg.Chat.Id)
+}
+
+func InstagramModuleTelegramHandler(b *gotgbot.Bot, c *ext.Context) error {
+	msg := c.EffectiveMessage
+
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+
+	target := TelegramTarget{
+		ChatID:           msg.Chat.Id,
+		ReplyToMessageID: msg.MessageId,
+	}
+	if msg.IsTopicMessage {
+		target.ThreadID = msg.MessageThreadId
+	}
+
+	var (
+		chatKey   = telegramChatKey(msg.Chat.Id)
+		senderKey = chatKey
+	)
+	if msg.From != nil {
+		senderKey = telegramChatKey(msg.From.Id)
+	}
+
+	// Links go through the same dedup, rate limits, quota and history as on
+	// WhatsApp, with the default quota as policies only cover WhatsApp chats
+	for _, token := range strings.Fields(text) {
+		isPost := IsSupportedLink(token) && !IsStoriesLink(token)
+		if !isPost && !IsInstagramLink(token) {
+			continue
+		}
+
+		if earlier := FindEarlierShare(chatKey, ShortcodeToMediaID(LinkShortcode(token))); earlier != nil {
+			telegramReplyAlreadyShared(target, earlier)
+			continue
+		}
+		reservation := telegramReserveDownload(target, chatKey, senderKey)
+		if reservation == nil {
+			return nil
+		}
+
+		job := NewDownloadJob()
+
+		var items int
+		if isPost {
+			items = telegramDownloadLink(job, token, msg, target)
+		} else {
+			items = telegramUserProfile(job, token, target)
+		}
+
+		reservation.Settle(items, job.TotalSize())
+		job.Cleanup()
+	}
+
+	return nil
+}
+
+func telegramChatKey(id int64) string {
+	return fmt.Sprintf("telegram:%d", id)
+}
+
+// telegramReserveDownload is reserveDownload for Telegram chats.
+func telegramReserveDownload(target TelegramTarget, chatKey, senderKey string) *QuotaReservation {
+	reservation, wait := ReserveQuota(chatKey+"/"+senderKey, instaConfig.RateLimits.Quota)
+	if reservation == nil {
+		telegramReplyText(target, fmt.Sprintf("You have used up today's download quota, try again in %s",
+			FormatWait(wait)))
+		return nil
+	}
+	if allowed, wait := takeRateLimitToken(chatKey, senderKey); !allowed {
+		reservation.Settle(0, 0)
+		telegramReplyText(target, fmt.Sprintf("Too many links, try again in %s", FormatWait(wait)))
+		return nil
+	}
+	return reservation
+}
+
+func telegramReplyAlreadyShared(target TelegramTarget, earlier *history.Entry) {
+	sharedBy := earlier.SenderName
+	if sharedBy == "" {
+		sharedBy = "someone"
+	}
+
+	text := fmt.Sprintf("Already shared by %s %s", sharedBy, FormatAgo(time.Since(earlier.CreatedAt)))
+	if messageID, err := strconv.ParseInt(earlier.MessageID, 10, 64); err == nil {
+		target.ReplyToMessageID = messageID
+	} else {
+		text += "\n\n" + earlier.Link
+	}
+	telegramReplyText(target, text)
+}
+
+func telegramReplyText(target TelegramTarget, text string) {
+	state.State.TelegramBot.SendMessage(target.ChatID, text, &gotgbot.SendMessageOpts{
+		MessageThreadId:  target.ThreadID,
+		ReplyToMessageId: target.ReplyToMessageID,
+	})
+}
+
+// telegramDownloadLink sends the media of a post, reel or IGTV link to the
+// Telegram chat and returns how many items were delivered.
+func telegramDownloadLink(job *DownloadJob, link string, msg *gotgbot.Message, target TelegramTarget) int {
+	entry := &history.Entry{
+		Link:       link,
+		Chat:       telegramChatKey(msg.Chat.Id),
+		SenderName: msg.Chat.Title,
+		MessageID:  strconv.FormatInt(msg.MessageId, 10),
+		Outcome:    history.Failed,
+	}
+	if msg.From != nil {
+		entry.Sender, entry.SenderName = telegramChatKey(msg.From.Id), msg.From.FirstName
+	}
+	defer RecordHistory(entry)
+
+	post, err := FetchPost(link)
+	if err != nil {
+		telegramReplyText(target, err.Error())
+		return 0
+	}
+	setHistoryPost(entry, post)
+
+	files := make([]*DownloadedFile, len(post.Media))
+	errs := forEachBounded(len(post.Media), carouselConcurrency(), func(ctx context.Context, idx int) error {
+		var err error
+		files[idx], _, err = DownloadMedia(ctx, job, post.Media[idx])
+		return err
+	})
+
+	for _, err := range errs {
+		if errors.Is(err, ErrJobSizeLimit) {
+			telegramReplyText(target, fmt.Sprintf("Aborted downloading the post:\n\n%s", err.Error()))
+			return 0
+		}
+	}
+
+	var (
+		downloaded = make([]*DownloadedFile, 0, len(files))
+		sources    = make([]MediaSource, 0, len(files))
+	)
+	for idx, file := range files {
+		if file != nil {
+			downloaded = append(downloaded, file)
+			sources = append(sources, post.Media[idx])
+		}
+	}
+
+	if len(downloaded) == 0 {
+		telegramReplyText(target, "Could not download the media")
+		return 0
+	}
+
+	ArchivePost(post, files, ArchiveRequest{
+		Chat:      entry.Chat,
+		MessageID: fmt.Sprint(target.ReplyToMessageID),
+	})
+
+	err = SendFilesToTelegram(target, post.Caption, downloaded, sources)
+	if err != nil {
+		telegramReplyText(target, fmt.Sprintf("Could not send the media:\n\n%s", err.Error()))
+		return 0
+	}
+	entry.SetItems(len(downloaded), len(post.Media))
+	return len(downloaded)
+}
+
+// telegramUserProfile sends the profile picture and details of a profile link
+// to the Telegram chat, and returns how many items were delivered.
+func telegramUserProfile(job *DownloadJob, link string, target TelegramTarget) int {
+	iup, err := FetchUserProfile(link)
+	if err != nil {
+		return 0
+	}
+
+	source := iup.MediaSource()
+	dpFile, _, err := DownloadMedia(context.Background(), job, source)
+	if err != nil {
+		return 0
+	}
+
+	if SendFilesToTelegram(target, iup.Caption(), []*DownloadedFile{dpFile}, []MediaSource{source}) != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,122 @@
+package instagram
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"os"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ThumbnailMaxSide = 72
+	ThumbnailQuality = 60
+)
+
+// MakeThumbnail decodes the image at path and returns a small JPEG preview of
+// it along with the dimensions of the original image.
+func MakeThumbnail(path string) ([]byte, int32, int32, error) {
+	img, err := decodeImageFile(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	thumbWidth, thumbHeight := width, height
+	if width > ThumbnailMaxSide || height > ThumbnailMaxSide {
+		if width >= height {
+			thumbWidth, thumbHeight = ThumbnailMaxSide, height*ThumbnailMaxSide/width
+		} else {
+			thumbWidth, thumbHeight = width*ThumbnailMaxSide/height, ThumbnailMaxSide
+		}
+	}
+	if thumbWidth < 1 {
+		thumbWidth = 1
+	}
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: ThumbnailQuality})
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("could not encode thumbnail : %s", err)
+	}
+
+	return buf.Bytes(), int32(width), int32(height), nil
+}
+
+func decodeImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image : %s", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image : %s", err)
+	}
+	return img, nil
+}
+
+// CoverThumbnail downloads the smallest usable cover image out of the given
+// candidates and turns it into a thumbnail.
+func CoverThumbnail(job *DownloadJob, candidates ...ImageVersion) ([]byte, int32, int32, error) {
+	coverLink := smallestCandidate(candidates, ThumbnailMaxSide)
+	if coverLink == "" {
+		return nil, 0, 0, fmt.Errorf("no cover image available")
+	}
+
+	req, _ := http.NewRequest("GET", coverLink, nil)
+	coverFile, err := job.Download(req)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return MakeThumbnail(coverFile.Path)
+}
+
+// smallestCandidate returns the lowest resolution candidate that still has
+// both sides at least minSide long, falling back to the biggest one otherwise.
+func smallestCandidate(candidates []ImageVersion, minSide int32) string {
+	var (
+		best        *ImageVersion
+		biggest     *ImageVersion
+		isBigEnough = func(c *ImageVersion) bool {
+			return c.Width >= minSide && c.Height >= minSide
+		}
+	)
+
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.URL == "" {
+			continue
+		}
+		if biggest == nil || candidate.Width*candidate.Height > biggest.Width*biggest.Height {
+			biggest = candidate
+		}
+		if isBigEnough(candidate) && (best == nil || candidate.Width*candidate.Height < best.Width*best.Height) {
+			best = candidate
+		}
+	}
+
+	if best != nil {
+		return best.URL
+	} else if biggest != nil {
+		return biggest.URL
+	}
+	return ""
+}
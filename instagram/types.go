@@ -74,6 +74,7 @@ type InstagramImage struct {
 			Candidates []ImageVersion `json:"candidates,omitempty"`
 		} `json:"image_versions2,omitempty"`
 		MediaType                  int   `json:"media_type,omitempty"`
+		TakenAt                    int64 `json:"taken_at,omitempty"`
 		CommentCount               int64 `json:"comment_count,omitempty"`
 		LikeCount                  int64 `json:"like_count,omitempty"`
 		Height                     int32 `json:"original_height,omitempty"`
@@ -85,33 +86,37 @@ type InstagramImage struct {
 	} `json:"items,omitempty"`
 }
 
+// VideoImageVersions are the images of a video, which include its first frame
+type VideoImageVersions struct {
+	AdditionalCandidates struct {
+		IGTVFirstFrame ImageVersion `json:"igtv_first_frame,omitempty"`
+		FirstFrame     ImageVersion `json:"first_frame,omitempty"`
+	} `json:"additional_candidates,omitempty"`
+	Candidates []ImageVersion `json:"candidates,omitempty"`
+}
+
 type VideoItem struct {
-	ImageVersions struct {
-		AdditionalCandidates struct {
-			IGTVFirstFrame ImageVersion `json:"igtv_first_frame,omitempty"`
-			FirstFrame     ImageVersion `json:"first_frame,omitempty"`
-		} `json:"additional_candidates,omitempty"`
-		Candidates []ImageVersion `json:"candidates,omitempty"`
-	} `json:"image_versions2,omitempty"`
-	Code                       string           `json:"code,omitempty"`
-	ID                         string           `json:"id,omitempty"`
-	Caption                    InstagramCaption `json:"caption,omitempty"`
-	User                       InstagramUser    `json:"user,omitempty"`
-	VideoVersions              []VideoVersion   `json:"video_versions,omitempty"`
-	TopLikers                  []string         `json:"top_likers,omitempty"`
-	MediaType                  int              `json:"media_type,omitempty"`
-	PK                         int64            `json:"pk"`
-	CommentCount               int64            `json:"comment_count,omitempty"`
-	LikeCount                  int64            `json:"like_count,omitempty"`
-	ViewCount                  int64            `json:"view_count,omitempty"`
-	PlayCount                  int64            `json:"play_count,omitempty"`
-	VideoDuration              float64          `json:"video_duration,omitempty"`
-	Height                     int32            `json:"original_height,omitempty"`
-	Width                      int32            `json:"original_width,omitempty"`
-	HaveLiked                  bool             `json:"has_liked,omitempty"`
-	IsPhotoOfMe                bool             `json:"photo_of_you,omitempty"`
-	IsLikeAndViewCountDisabled bool             `json:"like_and_view_counts_disabled,omitempty"`
-	IsCaptionEdited            bool             `json:"caption_is_edited,omitempty"`
+	ImageVersions              VideoImageVersions `json:"image_versions2,omitempty"`
+	Code                       string             `json:"code,omitempty"`
+	ID                         string             `json:"id,omitempty"`
+	Caption                    InstagramCaption   `json:"caption,omitempty"`
+	User                       InstagramUser      `json:"user,omitempty"`
+	VideoVersions              []VideoVersion     `json:"video_versions,omitempty"`
+	TopLikers                  []string           `json:"top_likers,omitempty"`
+	MediaType                  int                `json:"media_type,omitempty"`
+	PK                         int64              `json:"pk"`
+	TakenAt                    int64              `json:"taken_at,omitempty"`
+	CommentCount               int64              `json:"comment_count,omitempty"`
+	LikeCount                  int64              `json:"like_count,omitempty"`
+	ViewCount                  int64              `json:"view_count,omitempty"`
+	PlayCount                  int64              `json:"play_count,omitempty"`
+	VideoDuration              float64            `json:"video_duration,omitempty"`
+	Height                     int32              `json:"original_height,omitempty"`
+	Width                      int32              `json:"original_width,omitempty"`
+	HaveLiked                  bool               `json:"has_liked,omitempty"`
+	IsPhotoOfMe                bool               `json:"photo_of_you,omitempty"`
+	IsLikeAndViewCountDisabled bool               `json:"like_and_view_counts_disabled,omitempty"`
+	IsCaptionEdited            bool               `json:"caption_is_edited,omitempty"`
 }
 
 type InstagramReel struct {
@@ -128,6 +133,7 @@ type InstagramCarousel struct {
 		CarouselMedia              []CarouselMedia  `json:"carousel_media,omitempty"`
 		TopLikers                  []string         `json:"top_likers,omitempty"`
 		MediaType                  int              `json:"media_type,omitempty"`
+		TakenAt                    int64            `json:"taken_at,omitempty"`
 		CommentCount               int64            `json:"comment_count,omitempty"`
 		LikeCount                  int64            `json:"like_count,omitempty"`
 		CarouselMediaCount         int32            `json:"carousel_media_count,omitempty"`
@@ -172,15 +178,13 @@ type VideoVersion struct {
 }
 
 type CarouselMedia struct {
-	ID            string `json:"id,omitempty"`
-	ImageVersions struct {
-		Candidates []ImageVersion `json:"candidates,omitempty"`
-	} `json:"image_versions2,omitempty"`
-	VideoVersions []VideoVersion `json:"video_versions,omitempty"`
-	MediaType     int            `json:"media_type,omitempty"`
-	VideoDuration float64        `json:"video_duration,omitempty"`
-	Height        int32          `json:"original_height"`
-	Width         int32          `json:"original_weight"`
+	ID            string             `json:"id,omitempty"`
+	ImageVersions VideoImageVersions `json:"image_versions2,omitempty"`
+	VideoVersions []VideoVersion     `json:"video_versions,omitempty"`
+	MediaType     int                `json:"media_type,omitempty"`
+	VideoDuration float64            `json:"video_duration,omitempty"`
+	Height        int32              `json:"original_height"`
+	Width         int32              `json:"original_width"`
 }
 
 func (ii InstagramImage) Caption() string {
@@ -354,6 +358,19 @@ func (ir InstagramReel) DownloadLink() string {
 	return link
 }
 
+// CompatibleDownloadLink is like DownloadLink but probes the video versions
+// and prefers one which every WhatsApp client can play.
+func (ir InstagramReel) CompatibleDownloadLink() (string, *MP4Info) {
+	if len(ir.Items) == 0 {
+		return "", nil
+	}
+	version, info := SelectVideoVersion(ir.Items[0].VideoVersions)
+	if info == nil {
+		return ir.DownloadLink(), nil
+	}
+	return version.URL, info
+}
+
 func (is InstagramStory) DownloadLink(mediaID int64) string {
 	if len(is.Items) == 0 {
 		return ""
@@ -431,6 +448,33 @@ func (cm CarouselMedia) DownloadLink() string {
 	return ""
 }
 
+// CompatibleDownloadLink is like DownloadLink but probes the video versions
+// and prefers one which every WhatsApp client can play.
+func (cm CarouselMedia) CompatibleDownloadLink() (string, *MP4Info) {
+	if cm.MediaType != MediaTypeVideo {
+		return cm.DownloadLink(), nil
+	}
+	version, info := SelectVideoVersion(cm.VideoVersions)
+	if info == nil {
+		return cm.DownloadLink(), nil
+	}
+	return version.URL, info
+}
+
+func (vi VideoItem) CoverCandidates() []ImageVersion {
+	return vi.ImageVersions.CoverCandidates()
+}
+
+func (cm CarouselMedia) CoverCandidates() []ImageVersion {
+	return cm.ImageVersions.CoverCandidates()
+}
+
+func (versions VideoImageVersions) CoverCandidates() []ImageVersion {
+	additional := versions.AdditionalCandidates
+	candidates := append([]ImageVersion{}, versions.Candidates...)
+	return append(candidates, additional.FirstFrame, additional.IGTVFirstFrame)
+}
+
 func (iup InstagramUserProfile) Followers() int64 {
 	return iup.Graphql.User.EdgeFollowedBy.Count
 }
@@ -3,7 +3,6 @@ package instagram
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -83,6 +82,9 @@ func AddHeaders(req *http.Request) {
 }
 
 func AddCookies(req *http.Request) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
 	cookies := instaConfig.Cookies
 	for k, v := range cookies {
 		req.AddCookie(&http.Cookie{Name: k, Value: v})
@@ -90,26 +92,32 @@ func AddCookies(req *http.Request) {
 }
 
 func SaveCookies(res *http.Response) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	changed := false
 	for _, cookie := range res.Cookies() {
-		if _, present := instaConfig.Cookies[cookie.Name]; present {
+		if value, present := instaConfig.Cookies[cookie.Name]; present && value != cookie.Value {
 			instaConfig.Cookies[cookie.Name] = cookie.Value
+			changed = true
 		}
 	}
-	instaConfig.SaveConfig()
+	if changed {
+		instaConfig.writeConfig()
+	}
 }
 
-func DownloadFile(req *http.Request) ([]byte, error) {
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("Error making request: %s", err.Error())
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Received status '%s'", res.Status)
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
-
-	return io.ReadAll(res.Body)
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
 func init() {